@@ -8,9 +8,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"kcdb"
 	"kcdb/admin"
+	"kcdb/creds"
 	"kcdb/db"
 	"kcdb/ingestor"
 )
@@ -19,12 +24,48 @@ var (
 	listenerFlag    = flag.String("listener", "localhost:8080", "Address to listen on")
 	updateDelayFlag = flag.Int("update-delay", 120, "Seconds between ingesting from sources")
 	adminSecretFlag = flag.String("admin-secret", "", "Secret to use for admin RPCs")
+	workersFlag     = flag.Int("ingest-workers", 1, "Number of sources to ingest in parallel")
+	maxFailuresFlag = flag.Int("max-ingest-failures", 10, "Consecutive failed ingests after which a source is disabled (0 to never disable)")
+	credsKeyFlag    = flag.String("credentials-key", "", "Secret used to encrypt source credentials (defaults to $KCDB_CREDENTIALS_KEY)")
+
+	shallowCloneFlag  = flag.Bool("shallow-clone", ingestor.DefaultLimits.ShallowClone, "Only fetch the most recent commit of git sources")
+	fetchTimeoutFlag  = flag.Duration("fetch-timeout", ingestor.DefaultLimits.FetchTimeout, "Maximum time to clone or fetch a source (0 for no limit)")
+	maxSourceSizeFlag = flag.Int64("max-source-size", ingestor.DefaultLimits.MaxSourceSize, "Maximum size of a source on disk in bytes (0 for no limit)")
+	maxFilesFlag      = flag.Int("max-files", ingestor.DefaultLimits.MaxFiles, "Maximum number of files in a source (0 for no limit)")
+	maxFileSizeFlag   = flag.Int64("max-file-size", ingestor.DefaultLimits.MaxFileSize, "Maximum size of a part file in bytes (0 for no limit)")
+	maxParseDepthFlag = flag.Int("max-parse-depth", ingestor.DefaultLimits.MaxParseDepth, "Maximum s-expression nesting in a footprint (0 for no limit)")
+	parseTimeoutFlag  = flag.Duration("parse-timeout", ingestor.DefaultLimits.ParseTimeout, "Maximum time to parse a single file (0 for no limit)")
+	timedOutFlag      = flag.Int("max-timed-out-parses", ingestor.DefaultLimits.MaxTimedOutParses, "Maximum parses from one source which timed out that may still be running (0 for no limit)")
 )
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 	admin.SetSecret(*adminSecretFlag)
+	credsKey := *credsKeyFlag
+	if credsKey == "" {
+		credsKey = os.Getenv("KCDB_CREDENTIALS_KEY")
+	}
+	if err := creds.SetKey(credsKey); err != nil {
+		fmt.Printf("Failed to set credentials key: %v\n", err)
+		os.Exit(1)
+	}
+	ingestor.SetLimits(ingestor.Limits{
+		ShallowClone:  *shallowCloneFlag,
+		FetchTimeout:  *fetchTimeoutFlag,
+		MaxSourceSize: *maxSourceSizeFlag,
+		MaxFiles:      *maxFilesFlag,
+		MaxFileSize:   *maxFileSizeFlag,
+		MaxParseDepth: *maxParseDepthFlag,
+		ParseTimeout:  *parseTimeoutFlag,
+
+		MaxTimedOutParses: *timedOutFlag,
+	})
+
+	// ingest-dir does not use the database, so runs before it is opened.
+	if flag.Arg(0) == "ingest-dir" {
+		os.Exit(ingestDir(flag.Args()[1:]))
+	}
 
 	initHandlers()
 	_, err := db.Init(ctx, "kc.db")
@@ -37,14 +78,26 @@ func main() {
 	case "add-git-source":
 		newGitSource(ctx, flag.Arg(1))
 
+	case "add-dir-source":
+		newDirSource(ctx, flag.Arg(1))
+
+	case "add-archive-source":
+		newArchiveSource(ctx, flag.Arg(1))
+
 	case "dump-sources":
 		dumpSources(ctx)
 
 	case "load-sources":
 		loadSources(ctx)
 
+	case "ingest":
+		ingestSource(ctx, flag.Arg(1))
+
+	case "set-credentials":
+		setCredentials(ctx, flag.Arg(1), flag.Arg(2))
+
 	case "", "run":
-		if err := ingestor.Start(*updateDelayFlag); err != nil {
+		if err := ingestor.Start(*updateDelayFlag, *workersFlag, *maxFailuresFlag); err != nil {
 			fmt.Printf("Failed to setup ingestor: %v\n", err)
 			os.Exit(1)
 		}
@@ -67,6 +120,9 @@ func dumpSources(ctx context.Context) {
 	fmt.Println(string(j))
 }
 
+// loadSources creates or updates sources from a file written by dump-sources.
+// Webhook secrets and credentials are not dumped, so are not set on created
+// sources, and are kept when the metadata of an existing source is updated.
 func loadSources(ctx context.Context) {
 	d, err := ioutil.ReadFile(flag.Arg(1))
 	if err != nil {
@@ -81,20 +137,188 @@ func loadSources(ctx context.Context) {
 	for _, source := range sources {
 		fmt.Printf("[%.3d] %s ... ", source.UID, source.URL)
 
+		if _, err := source.ParseMetadata(); err != nil {
+			fmt.Println("Err!\n\tBad metadata: " + err.Error())
+			continue
+		}
+
 		s, err := db.GetSource(ctx, source.UID, db.DB())
 		if err == os.ErrNotExist || s.URL != source.URL {
 			if err := db.CreateSource(ctx, &source, db.DB()); err != nil {
 				fmt.Println("Err!\n\t" + err.Error())
+			} else if source.Private {
+				fmt.Println("Created! Its credentials must be set again with set-credentials.")
 			} else {
 				fmt.Println("Created!")
 			}
 			continue
 		}
 
+		if s.Metadata != source.Metadata {
+			if err := db.SetSourceMetadata(ctx, s.UID, source.Metadata, db.DB()); err != nil {
+				fmt.Println("Err!\n\t" + err.Error())
+			} else {
+				fmt.Println("Updated.")
+			}
+			continue
+		}
+
 		fmt.Println("Exists.")
 	}
 }
 
+// findSource returns the source with the given UID or URL, exiting if there
+// is none.
+func findSource(ctx context.Context, ident string) *db.Source {
+	var (
+		s   *db.Source
+		err error
+	)
+	if uid, convErr := strconv.Atoi(ident); convErr == nil {
+		s, err = db.GetSource(ctx, uid, db.DB())
+	} else {
+		s, err = db.GetSourceByURL(ctx, ident, db.DB())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find source %q: %v\n", ident, err)
+		os.Exit(1)
+	}
+	return s
+}
+
+func ingestSource(ctx context.Context, ident string) {
+	s := findSource(ctx, ident)
+	start := time.Now()
+	r, err := ingestor.Ingest(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ingest failed: %v\n", err)
+		os.Exit(1)
+	}
+	if r == nil {
+		fmt.Printf("%s is already up to date.\n", s.URL)
+		return
+	}
+	fmt.Printf("Ingested %s in %v: %d parts, %d pruned, %d files could not be parsed.\n",
+		s.URL, time.Since(start).Round(time.Millisecond), r.Parts, r.Pruned, r.Failed)
+}
+
+// setCredentials sets the credentials of a source from a JSON file, or from
+// stdin if the path is "-". Credentials with an empty kind are removed.
+func setCredentials(ctx context.Context, ident, path string) {
+	if ident == "" || path == "" {
+		fmt.Fprintf(os.Stderr, "Usage: kcdb set-credentials <source uid or url> <credentials.json>\n")
+		os.Exit(2)
+	}
+	s := findSource(ctx, ident)
+
+	var (
+		d   []byte
+		err error
+	)
+	if path == "-" {
+		d, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		d, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read credentials: %v\n", err)
+		os.Exit(1)
+	}
+	var c creds.Credentials
+	if err := json.Unmarshal(d, &c); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to decode credentials: %v\n", err)
+		os.Exit(1)
+	}
+
+	var sealed string
+	if c.Kind != "" {
+		if err := c.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad credentials: %v\n", err)
+			os.Exit(1)
+		}
+		if sealed, err = creds.Seal(&c); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encrypt credentials: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := db.SetSourceCredentials(ctx, s.UID, sealed, db.DB()); err != nil {
+		fmt.Fprintf(os.Stderr, "SetSourceCredentials() failed: %v\n", err)
+		os.Exit(1)
+	}
+	if sealed == "" {
+		fmt.Printf("Removed the credentials of %s.\n", s.URL)
+	} else {
+		fmt.Printf("Set %v on %s.\n", &c, s.URL)
+	}
+}
+
+// ingestDir reports the parts which would be ingested from a directory, and
+// returns the exit code of the command.
+func ingestDir(args []string) int {
+	fs := flag.NewFlagSet("ingest-dir", flag.ExitOnError)
+	jsonFlag := fs.Bool("json", false, "Output the report as JSON")
+	urlFlag := fs.String("url", "", "URL the parts would be attributed to, such as the URL of the repository (defaults to the directory)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kcdb ingest-dir [flags] <path>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	// Stdout is reserved for the report, so the ingestor logs to stderr.
+	r, err := ingestor.DryRun(fs.Arg(0), *urlFlag, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ingest failed: %v\n", err)
+		return 1
+	}
+
+	if *jsonFlag {
+		j, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Println(string(j))
+	} else {
+		printReport(r)
+	}
+	if len(r.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printReport(r *ingestor.Report) {
+	fmt.Printf("Found %d parts in %d files under %s.\n", len(r.Parts), r.Files, r.Root)
+	for _, p := range r.Parts {
+		if p.Board != "" {
+			fmt.Printf("  %-9s  %s  (from board %s)\n", p.Kind, p.URL, p.Board)
+		} else {
+			fmt.Printf("  %-9s  %s\n", p.Kind, p.URL)
+		}
+	}
+	if len(r.Duplicates) > 0 {
+		fmt.Printf("\n%d names are used by more than one part:\n", len(r.Duplicates))
+		for _, d := range r.Duplicates {
+			fmt.Printf("  %s %q:\n", d.Kind, d.Name)
+			for _, u := range d.URLs {
+				fmt.Printf("    %s\n", u)
+			}
+		}
+	}
+	if len(r.Referenced) > 0 {
+		fmt.Printf("\nLibrary tables reference %d repositories:\n", len(r.Referenced))
+		for _, u := range r.Referenced {
+			fmt.Printf("  %s\n", u)
+		}
+	}
+	if len(r.Failures) > 0 {
+		fmt.Printf("\n%d files could not be parsed:\n", len(r.Failures))
+		for _, f := range r.Failures {
+			fmt.Printf("  %s: %s\n", f.Path, f.Message)
+		}
+	}
+}
+
 func newGitSource(ctx context.Context, url string) {
 	err := db.AddSource(ctx, &db.Source{
 		Kind: db.SourceKindGit,
@@ -106,6 +330,48 @@ func newGitSource(ctx context.Context, url string) {
 	}
 }
 
+func newDirSource(ctx context.Context, path string) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		fmt.Printf("Failed to resolve path: %v\n", err)
+		os.Exit(1)
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		fmt.Printf("%q is not a directory\n", path)
+		os.Exit(1)
+	}
+	err = db.AddSource(ctx, &db.Source{
+		Kind: db.SourceKindDir,
+		URL:  path,
+	}, db.DB())
+	if err != nil {
+		fmt.Printf("Failed to add directory source: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newArchiveSource(ctx context.Context, location string) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		var err error
+		if location, err = filepath.Abs(location); err != nil {
+			fmt.Printf("Failed to resolve path: %v\n", err)
+			os.Exit(1)
+		}
+		if info, err := os.Stat(location); err != nil || !info.Mode().IsRegular() {
+			fmt.Printf("%q is not a file\n", location)
+			os.Exit(1)
+		}
+	}
+	err := db.AddSource(ctx, &db.Source{
+		Kind: db.SourceKindArchive,
+		URL:  location,
+	}, db.DB())
+	if err != nil {
+		fmt.Printf("Failed to add archive source: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func makeServer() *http.Server {
 	// make our server objects
 	s := &http.Server{
@@ -119,12 +385,29 @@ func initHandlers() {
 	http.Handle("/", fs)
 	http.HandleFunc("/module/details", kcdb.ModuleDetails)
 	http.HandleFunc("/module/details/", kcdb.ModuleDetails)
+	http.HandleFunc("/module/download/", kcdb.ModuleDownload)
 	http.HandleFunc("/footprint/", kcdb.FootprintHandler)
 	http.HandleFunc("/symbol/", kcdb.SymbolHandler)
 	http.HandleFunc("/sym/details/", kcdb.SymbolDetails)
+	http.HandleFunc("/module/history/", kcdb.ModuleHistory)
+	http.HandleFunc("/sym/history/", kcdb.SymbolHistory)
 	http.HandleFunc("/sources/all", kcdb.ListSources)
+	http.HandleFunc("/sources/suggest", kcdb.SuggestSource)
+	http.HandleFunc("/sources/", kcdb.SourceErrors)
 	http.HandleFunc("/search/all", kcdb.SearchHandler)
 	http.HandleFunc("/ingestor/status", kcdb.IngestState)
+	http.HandleFunc("/ingestor/events", kcdb.IngestEvents)
+	http.HandleFunc("/webhook", kcdb.WebhookHandler)
 	http.HandleFunc("/admin/sources/params", admin.UpdateSourceAdmin)
 	http.HandleFunc("/admin/sources/add", admin.AddSourceAdmin)
+	http.HandleFunc("/admin/sources/config", admin.ConfigureSourceAdmin)
+	http.HandleFunc("/admin/sources/ingest", admin.IngestSourceAdmin)
+	http.HandleFunc("/admin/sources/webhook", admin.WebhookSecretAdmin)
+	http.HandleFunc("/admin/sources/disable", admin.DisableSourceAdmin)
+	http.HandleFunc("/admin/sources/credentials", admin.CredentialsAdmin)
+	http.HandleFunc("/admin/sources/candidates", admin.SourceCandidatesAdmin)
+	http.HandleFunc("/admin/sources/candidates/promote", admin.PromoteCandidateAdmin)
+	http.HandleFunc("/admin/sources/suggestions", admin.SuggestionsAdmin)
+	http.HandleFunc("/admin/sources/suggestions/approve", admin.ApproveSuggestionAdmin)
+	http.HandleFunc("/admin/sources/suggestions/reject", admin.RejectSuggestionAdmin)
 }
@@ -134,7 +134,7 @@ func parseZone(n sexp.Helper, ordering int) (*Zone, error) {
 			for j := 1; j < c.MustNode().NumChildren(); j++ {
 				z.Layers = append(z.Layers, c.Child(j).MustString())
 			}
-		case "tstamp":
+		case "tstamp", "uuid":
 			z.Tstamp = c.Child(1).MustString()
 
 		case "hatch":
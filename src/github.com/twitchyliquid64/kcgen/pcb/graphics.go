@@ -189,6 +189,10 @@ func parseTextEffects(n sexp.Helper) (TextEffects, error) {
 	var e TextEffects
 	for y := 1; y < n.MustNode().NumChildren(); y++ {
 		c := n.Child(y)
+		if c.IsScalar() {
+			// Such as hide, which KiCad 6+ may place within the effects.
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "font":
 			for z := 1; z < c.MustNode().NumChildren(); z++ {
@@ -212,6 +216,10 @@ func parseTextEffects(n sexp.Helper) (TextEffects, error) {
 					e.FontSize.Y = c.Child(2).MustFloat64()
 				case "thickness":
 					e.Thickness = c.Child(1).MustFloat64()
+				case "bold":
+					e.Bold = c.Child(1).MustString() == "yes"
+				case "italic":
+					e.Italic = c.Child(1).MustString() == "yes"
 				}
 			}
 		case "justify":
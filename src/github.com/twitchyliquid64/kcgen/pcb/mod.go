@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 
 	"github.com/nsf/sexp"
@@ -14,6 +15,10 @@ import (
 type Module struct {
 	Name string `json:"name"`
 
+	// Version and Generator are set for footprints saved by KiCad 6 or later.
+	Version   int    `json:"version,omitempty"`
+	Generator string `json:"generator,omitempty"`
+
 	Placement ModPlacement `json:"placement"`
 	Placed    bool         `json:"placed"`
 	Locked    bool         `json:"locked"`
@@ -35,11 +40,22 @@ type Module struct {
 	Attrs       []string `json:"attrs"`
 	order       int
 
+	// Properties holds the properties of a KiCad 7+ footprint, other than
+	// the reference and value which are stored as text graphics.
+	Properties []ModProperty `json:"properties,omitempty"`
+
 	Graphics []ModGraphic `json:"graphics"`
 	Pads     []Pad        `json:"pads"`
+	Zones    []Zone       `json:"zones,omitempty"`
 	Models   []ModModel   `json:"models,omitempty"`
 }
 
+// ModProperty is a named property of a module.
+type ModProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 // ModPlacement describes the positioning of a module on a PCB.
 type ModPlacement struct {
 	At XYZ `json:"position"`
@@ -111,6 +127,23 @@ type ModCircle struct {
 	End    XY      `json:"end"`
 	Layer  string  `json:"layer"`
 	Width  float64 `json:"width"`
+	Fill   bool    `json:"fill,omitempty"`
+}
+
+// ModRect represents a rectangle drawn in a module.
+type ModRect struct {
+	Start XY      `json:"start"`
+	End   XY      `json:"end"`
+	Layer string  `json:"layer"`
+	Width float64 `json:"width"`
+	Fill  bool    `json:"fill,omitempty"`
+}
+
+// ModCurve represents a cubic bezier curve drawn in a module.
+type ModCurve struct {
+	Points []XY    `json:"points"`
+	Layer  string  `json:"layer"`
+	Width  float64 `json:"width"`
 }
 
 // ModArc represents an arc drawn in a module.
@@ -271,9 +304,13 @@ func parseModule(n sexp.Helper, ordering int) (*Module, error) {
 		}
 
 		switch c.Child(0).MustString() {
+		case "version":
+			m.Version = c.Child(1).MustInt()
+		case "generator":
+			m.Generator = c.Child(1).MustString()
 		case "tedit":
 			m.Tedit = c.Child(1).MustString()
-		case "tstamp":
+		case "tstamp", "uuid":
 			m.Tstamp = c.Child(1).MustString()
 		case "layer":
 			m.Layer = c.Child(1).MustString()
@@ -283,7 +320,10 @@ func parseModule(n sexp.Helper, ordering int) (*Module, error) {
 			m.Path = c.Child(1).MustString()
 
 		case "attr":
-			m.Attrs = strings.Split(c.Child(1).MustString(), " ")
+			// KiCad 6+ lists each attribute separately.
+			for j := 1; j < c.MustNode().NumChildren(); j++ {
+				m.Attrs = append(m.Attrs, strings.Split(c.Child(j).MustString(), " ")...)
+			}
 		case "tags":
 			m.Tags = strings.Split(c.Child(1).MustString(), " ")
 
@@ -316,6 +356,25 @@ func parseModule(n sexp.Helper, ordering int) (*Module, error) {
 				Renderable: t,
 			})
 
+		case "property":
+			// KiCad 8 stores the reference and value text as properties.
+			switch name := c.Child(1).MustString(); name {
+			case "Reference", "Value":
+				t, err := parseModText(c)
+				if err != nil {
+					return nil, err
+				}
+				m.Graphics = append(m.Graphics, ModGraphic{
+					Ident:      "fp_text",
+					Renderable: t,
+				})
+			default:
+				m.Properties = append(m.Properties, ModProperty{
+					Name:  name,
+					Value: c.Child(2).MustString(),
+				})
+			}
+
 		case "fp_line":
 			l, err := parseModLine(c)
 			if err != nil {
@@ -356,7 +415,25 @@ func parseModule(n sexp.Helper, ordering int) (*Module, error) {
 				Renderable: a,
 			})
 
-			// TODO: curve
+		case "fp_rect":
+			r, err := parseModRect(c)
+			if err != nil {
+				return nil, err
+			}
+			m.Graphics = append(m.Graphics, ModGraphic{
+				Ident:      c.Child(0).MustString(),
+				Renderable: r,
+			})
+
+		case "fp_curve":
+			a, err := parseModCurve(c)
+			if err != nil {
+				return nil, err
+			}
+			m.Graphics = append(m.Graphics, ModGraphic{
+				Ident:      c.Child(0).MustString(),
+				Renderable: a,
+			})
 
 		case "pad":
 			pad, err := parseModPad(c)
@@ -365,6 +442,13 @@ func parseModule(n sexp.Helper, ordering int) (*Module, error) {
 			}
 			m.Pads = append(m.Pads, *pad)
 
+		case "zone":
+			z, err := parseZone(c, len(m.Zones))
+			if err != nil {
+				return nil, err
+			}
+			m.Zones = append(m.Zones, *z)
+
 		case "model":
 			model, err := parseModModel(c)
 			if err != nil {
@@ -382,9 +466,9 @@ func parseModText(n sexp.Helper) (*ModText, error) {
 	}
 
 	switch n.Child(1).MustString() {
-	case "reference":
+	case "reference", "Reference":
 		t.Kind = RefText
-	case "value":
+	case "value", "Value":
 		t.Kind = ValueText
 	case "user":
 		t.Kind = UserText
@@ -394,17 +478,23 @@ func parseModText(n sexp.Helper) (*ModText, error) {
 
 	for x := 3; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
-		if c.MustNode().Value == "hide" {
-			t.Hidden = true
+		if c.IsScalar() {
+			if c.MustNode().Value == "hide" {
+				t.Hidden = true
+			}
 			continue
 		}
 
 		switch c.Child(0).MustString() {
+		case "hide":
+			t.Hidden = c.Child(1).MustString() == "yes"
+		case "unlocked":
+			t.At.Unlocked = c.Child(1).MustString() == "yes"
 		case "at":
 			t.At.X = c.Child(1).MustFloat64()
 			t.At.Y = c.Child(2).MustFloat64()
 			for z := 3; z < c.MustNode().NumChildren(); z++ {
-				c := c.Child(x)
+				c := c.Child(z)
 				switch c.MustNode().Value {
 				case "unlocked":
 					t.At.Unlocked = true
@@ -431,6 +521,9 @@ func parseModLine(n sexp.Helper) (*ModLine, error) {
 	l := ModLine{}
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "start":
 			l.Start.X = c.Child(1).MustFloat64()
@@ -442,6 +535,8 @@ func parseModLine(n sexp.Helper) (*ModLine, error) {
 			l.Layer = c.Child(1).MustString()
 		case "width":
 			l.Width = c.Child(1).MustFloat64()
+		case "stroke":
+			l.Width = parseStrokeWidth(c)
 		}
 	}
 
@@ -452,6 +547,9 @@ func parseModPolygon(n sexp.Helper) (*ModPolygon, error) {
 	p := ModPolygon{}
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "at":
 			p.At.X = c.Child(1).MustFloat64()
@@ -459,48 +557,190 @@ func parseModPolygon(n sexp.Helper) (*ModPolygon, error) {
 		case "pts":
 			for j := 1; j < c.MustNode().NumChildren(); j++ {
 				c := c.Child(j)
-				if marker := c.Child(0).MustString(); marker != "xy" {
-					return nil, fmt.Errorf("expected 'xy', got %q", marker)
+				switch marker := c.Child(0).MustString(); marker {
+				case "xy":
+					p.Points = append(p.Points, XY{X: c.Child(1).MustFloat64(), Y: c.Child(2).MustFloat64()})
+				case "arc":
+					// KiCad 7+ polygons may contain arcs, which are
+					// approximated by straight segments.
+					pts := parseArcPoints(c)
+					if n := len(p.Points); n > 0 && p.Points[n-1] == pts[0] {
+						pts = pts[1:]
+					}
+					p.Points = append(p.Points, pts...)
+				default:
+					return nil, fmt.Errorf("expected 'xy' or 'arc', got %q", marker)
 				}
-				p.Points = append(p.Points, XY{X: c.Child(1).MustFloat64(), Y: c.Child(2).MustFloat64()})
 			}
 		case "layer":
 			p.Layer = c.Child(1).MustString()
 		case "width":
 			p.Width = c.Child(1).MustFloat64()
+		case "stroke":
+			p.Width = parseStrokeWidth(c)
 		}
 	}
 
 	return &p, nil
 }
 
+// arcSegmentAngle is the angle in degrees swept by each segment used to
+// approximate an arc in a polygon.
+const arcSegmentAngle = 10
+
+// parseArcPoints returns the points of straight segments which approximate
+// an arc in a polygon, from its start to its end.
+func parseArcPoints(n sexp.Helper) []XY {
+	var start, mid, end XY
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		c := n.Child(x)
+		p := XY{X: c.Child(1).MustFloat64(), Y: c.Child(2).MustFloat64()}
+		switch c.Child(0).MustString() {
+		case "start":
+			start = p
+		case "mid":
+			mid = p
+		case "end":
+			end = p
+		}
+	}
+	center, sweep, ok := arcFromPoints(start, mid, end)
+	if !ok {
+		return []XY{start, end}
+	}
+
+	segments := int(math.Ceil(math.Abs(sweep) / arcSegmentAngle))
+	radius := math.Hypot(start.X-center.X, start.Y-center.Y)
+	startAngle := pointAngle(center, start)
+	out := []XY{start}
+	for i := 1; i < segments; i++ {
+		a := (startAngle + sweep*float64(i)/float64(segments)) * math.Pi / 180
+		out = append(out, XY{
+			X: round6(center.X + radius*math.Cos(a)),
+			Y: round6(center.Y + radius*math.Sin(a)),
+		})
+	}
+	return append(out, end)
+}
+
+// parseModArc parses an arc. KiCad 5 describes arcs by their center (start),
+// a point on the arc (end) and the angle swept from it, while KiCad 6+
+// gives the start, middle and end points of the arc, which are converted.
 func parseModArc(n sexp.Helper) (*ModArc, error) {
 	a := ModArc{}
+	var start, mid, end XY
+	hasMid := false
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "start":
-			a.Start.X = c.Child(1).MustFloat64()
-			a.Start.Y = c.Child(2).MustFloat64()
+			start.X = c.Child(1).MustFloat64()
+			start.Y = c.Child(2).MustFloat64()
+		case "mid":
+			mid.X = c.Child(1).MustFloat64()
+			mid.Y = c.Child(2).MustFloat64()
+			hasMid = true
 		case "end":
-			a.End.X = c.Child(1).MustFloat64()
-			a.End.Y = c.Child(2).MustFloat64()
+			end.X = c.Child(1).MustFloat64()
+			end.Y = c.Child(2).MustFloat64()
 		case "layer":
 			a.Layer = c.Child(1).MustString()
 		case "width":
 			a.Width = c.Child(1).MustFloat64()
+		case "stroke":
+			a.Width = parseStrokeWidth(c)
 		case "angle":
 			a.Angle = c.Child(1).MustFloat64()
 		}
 	}
 
+	if !hasMid {
+		a.Start, a.End = start, end
+		return &a, nil
+	}
+	center, angle, ok := arcFromPoints(start, mid, end)
+	if !ok {
+		return nil, fmt.Errorf("arc points are collinear: %v, %v, %v", start, mid, end)
+	}
+	a.Start, a.End, a.Angle = center, start, angle
 	return &a, nil
 }
 
+// arcFromPoints returns the center of the arc which passes through start, mid
+// and end, and the angle in degrees it sweeps from start to end. ok is false
+// if the points are collinear.
+func arcFromPoints(start, mid, end XY) (center XY, angle float64, ok bool) {
+	d := 2 * (start.X*(mid.Y-end.Y) + mid.X*(end.Y-start.Y) + end.X*(start.Y-mid.Y))
+	if math.Abs(d) < 1e-9 {
+		return XY{}, 0, false
+	}
+	s2 := start.X*start.X + start.Y*start.Y
+	m2 := mid.X*mid.X + mid.Y*mid.Y
+	e2 := end.X*end.X + end.Y*end.Y
+	center = XY{
+		X: round6((s2*(mid.Y-end.Y) + m2*(end.Y-start.Y) + e2*(start.Y-mid.Y)) / d),
+		Y: round6((s2*(end.X-mid.X) + m2*(start.X-end.X) + e2*(mid.X-start.X)) / d),
+	}
+
+	// Angles increase clockwise, as the y axis points down.
+	startAngle := pointAngle(center, start)
+	sweep := normalizeAngle(pointAngle(center, end) - startAngle)
+	if normalizeAngle(pointAngle(center, mid)-startAngle) > sweep {
+		// The middle point is only reached going anticlockwise.
+		sweep -= 360
+	}
+	if sweep == 0 {
+		// The start and end points are the same, so the arc is a circle.
+		sweep = 360
+	}
+	return center, round6(sweep), true
+}
+
+func pointAngle(center, p XY) float64 {
+	return math.Atan2(p.Y-center.Y, p.X-center.X) * 180 / math.Pi
+}
+
+// normalizeAngle returns the angle in the range [0, 360).
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+func round6(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// parseStrokeWidth returns the width of a KiCad 6+ stroke expression.
+func parseStrokeWidth(n sexp.Helper) float64 {
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		c := n.Child(x)
+		if c.IsList() && c.Child(0).MustString() == "width" {
+			return c.Child(1).MustFloat64()
+		}
+	}
+	return 0
+}
+
+// parseFill returns whether a KiCad 6+ fill expression fills the shape, which
+// is written as (fill solid) by KiCad 6 & 7 and (fill yes) by KiCad 8.
+func parseFill(n sexp.Helper) bool {
+	v := n.Child(1).MustString()
+	return v == "solid" || v == "yes"
+}
+
 func parseModCircle(n sexp.Helper) (*ModCircle, error) {
 	a := ModCircle{}
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "center":
 			a.Center.X = c.Child(1).MustFloat64()
@@ -512,12 +752,75 @@ func parseModCircle(n sexp.Helper) (*ModCircle, error) {
 			a.Layer = c.Child(1).MustString()
 		case "width":
 			a.Width = c.Child(1).MustFloat64()
+		case "stroke":
+			a.Width = parseStrokeWidth(c)
+		case "fill":
+			a.Fill = parseFill(c)
 		}
 	}
 
 	return &a, nil
 }
 
+func parseModRect(n sexp.Helper) (*ModRect, error) {
+	r := ModRect{}
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
+		switch c.Child(0).MustString() {
+		case "start":
+			r.Start.X = c.Child(1).MustFloat64()
+			r.Start.Y = c.Child(2).MustFloat64()
+		case "end":
+			r.End.X = c.Child(1).MustFloat64()
+			r.End.Y = c.Child(2).MustFloat64()
+		case "layer":
+			r.Layer = c.Child(1).MustString()
+		case "width":
+			r.Width = c.Child(1).MustFloat64()
+		case "stroke":
+			r.Width = parseStrokeWidth(c)
+		case "fill":
+			r.Fill = parseFill(c)
+		}
+	}
+
+	return &r, nil
+}
+
+func parseModCurve(n sexp.Helper) (*ModCurve, error) {
+	b := ModCurve{}
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
+		switch c.Child(0).MustString() {
+		case "pts":
+			for j := 1; j < c.MustNode().NumChildren(); j++ {
+				c := c.Child(j)
+				if marker := c.Child(0).MustString(); marker != "xy" {
+					return nil, fmt.Errorf("expected 'xy', got %q", marker)
+				}
+				b.Points = append(b.Points, XY{X: c.Child(1).MustFloat64(), Y: c.Child(2).MustFloat64()})
+			}
+		case "layer":
+			b.Layer = c.Child(1).MustString()
+		case "width":
+			b.Width = c.Child(1).MustFloat64()
+		case "stroke":
+			b.Width = parseStrokeWidth(c)
+		}
+	}
+
+	if len(b.Points) != 4 {
+		return nil, fmt.Errorf("expected 4 points in curve, got %d", len(b.Points))
+	}
+	return &b, nil
+}
+
 func parseModPad(n sexp.Helper) (*Pad, error) {
 	p := Pad{
 		Ident:       n.Child(1).MustString(),
@@ -552,6 +855,10 @@ func parseModPad(n sexp.Helper) (*Pad, error) {
 
 	for x := 4; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
+		if c.IsScalar() {
+			// Such as locked, in KiCad 6+.
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "at":
 			p.At.X = c.Child(1).MustFloat64()
@@ -672,6 +979,24 @@ func parseModPad(n sexp.Helper) (*Pad, error) {
 						Ident:      c2.Child(0).MustString(),
 						Renderable: c,
 					})
+				case "gr_rect":
+					r, err := parseModRect(c2)
+					if err != nil {
+						return nil, err
+					}
+					p.Primitives = append(p.Primitives, ModGraphic{
+						Ident:      c2.Child(0).MustString(),
+						Renderable: r,
+					})
+				case "gr_curve":
+					b, err := parseModCurve(c2)
+					if err != nil {
+						return nil, err
+					}
+					p.Primitives = append(p.Primitives, ModGraphic{
+						Ident:      c2.Child(0).MustString(),
+						Renderable: b,
+					})
 				}
 			}
 
@@ -703,6 +1028,9 @@ func parseModModel(n sexp.Helper) (*ModModel, error) {
 
 	for x := 2; x < n.MustNode().NumChildren(); x++ {
 		c := n.Child(x)
+		if c.IsScalar() {
+			continue
+		}
 		switch c.Child(0).MustString() {
 		case "at":
 			m.At.X = c.Child(1).Child(1).MustFloat64()
@@ -0,0 +1,558 @@
+package pcb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// legacyDecimil is the size in millimeters of the unit used by legacy
+// libraries which do not specify their units.
+const legacyDecimil = 0.00254
+
+// legacyLayers maps the layer numbers used by legacy libraries to layer
+// names. Inner copper layers are handled by legacyLayerName.
+var legacyLayers = map[int]string{
+	0:  "B.Cu",
+	15: "F.Cu",
+	16: "B.Adhes",
+	17: "F.Adhes",
+	18: "B.Paste",
+	19: "F.Paste",
+	20: "B.SilkS",
+	21: "F.SilkS",
+	22: "B.Mask",
+	23: "F.Mask",
+	24: "Dwgs.User",
+	25: "Cmts.User",
+	26: "Eco1.User",
+	27: "Eco2.User",
+	28: "Edge.Cuts",
+}
+
+// legacyLayerName returns the name of the legacy layer number n.
+func legacyLayerName(n int) (string, error) {
+	if name, ok := legacyLayers[n]; ok {
+		return name, nil
+	}
+	if n > 0 && n < 15 {
+		return fmt.Sprintf("In%d.Cu", n), nil
+	}
+	return "", fmt.Errorf("unknown layer %d", n)
+}
+
+// legacyMaskLayers returns the names of the layers set in the hexadecimal
+// layer mask of a legacy pad.
+func legacyMaskLayers(mask string) ([]string, error) {
+	m, err := strconv.ParseUint(mask, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layer mask %q", mask)
+	}
+	var out []string
+	switch {
+	case m&(1<<15) != 0 && m&1 != 0:
+		out = append(out, "*.Cu")
+	default:
+		for n := 15; n >= 0; n-- {
+			if m&(1<<uint(n)) != 0 {
+				name, _ := legacyLayerName(n)
+				out = append(out, name)
+			}
+		}
+	}
+	// Technical layers come in back and front pairs.
+	for n := 16; n < 24; n += 2 {
+		back, front := m&(1<<uint(n)) != 0, m&(1<<uint(n+1)) != 0
+		switch {
+		case back && front:
+			out = append(out, "*"+strings.TrimPrefix(legacyLayers[n], "B"))
+		case front:
+			out = append(out, legacyLayers[n+1])
+		case back:
+			out = append(out, legacyLayers[n])
+		}
+	}
+	for n := 24; n <= 28; n++ {
+		if m&(1<<uint(n)) != 0 {
+			out = append(out, legacyLayers[n])
+		}
+	}
+	return out, nil
+}
+
+// legacyLine is a line of a legacy library, split into its values.
+type legacyLine struct {
+	values []string
+	scale  float64
+	err    error
+}
+
+func newLegacyLine(text string, scale float64) *legacyLine {
+	return &legacyLine{values: legacyTokens(text), scale: scale}
+}
+
+func (l *legacyLine) str(i int) string {
+	if i >= len(l.values) {
+		if l.err == nil {
+			l.err = fmt.Errorf("%s: expected at least %d values", l.values[0], i)
+		}
+		return ""
+	}
+	return l.values[i]
+}
+
+func (l *legacyLine) float(i int) float64 {
+	s := l.str(i)
+	if l.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		l.err = fmt.Errorf("%s: invalid number %q", l.values[0], s)
+	}
+	return v
+}
+
+// dim returns the i'th value as a distance in millimeters.
+func (l *legacyLine) dim(i int) float64 {
+	return round6(l.float(i) * l.scale)
+}
+
+// angle returns the i'th value, which is in tenths of a degree, in degrees.
+func (l *legacyLine) angle(i int) float64 {
+	return round6(l.float(i) / 10)
+}
+
+func (l *legacyLine) xy(i int) XY {
+	return XY{X: l.dim(i), Y: l.dim(i + 1)}
+}
+
+func (l *legacyLine) layer(i int) string {
+	n := int(l.float(i))
+	if l.err != nil {
+		return ""
+	}
+	name, err := legacyLayerName(n)
+	if err != nil {
+		l.err = err
+	}
+	return name
+}
+
+// legacyTokens splits a line into its whitespace separated values. Quoted
+// strings are returned as one value, without their quotes.
+func legacyTokens(s string) []string {
+	var out []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return out
+		}
+		if s[0] != '"' {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			out = append(out, s[:end])
+			s = s[end:]
+			continue
+		}
+
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		out = append(out, b.String())
+		if i < len(s) {
+			i++
+		}
+		s = s[i:]
+	}
+}
+
+// legacyParser reads a legacy library line by line.
+type legacyParser struct {
+	s     *bufio.Scanner
+	line  int
+	text  string
+	scale float64
+}
+
+// next advances to the next non-empty line.
+func (p *legacyParser) next() bool {
+	for p.s.Scan() {
+		p.line++
+		p.text = strings.TrimSpace(p.s.Text())
+		if p.text != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// keyword returns the first value on the current line.
+func (p *legacyParser) keyword() string {
+	if idx := strings.IndexAny(p.text, " \t"); idx >= 0 {
+		return p.text[:idx]
+	}
+	return p.text
+}
+
+// rest returns the current line after its keyword.
+func (p *legacyParser) rest() string {
+	return strings.TrimSpace(strings.TrimPrefix(p.text, p.keyword()))
+}
+
+func (p *legacyParser) values() *legacyLine {
+	return newLegacyLine(p.text, p.scale)
+}
+
+func (p *legacyParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// unterminated returns the error for a library which ends within a block.
+func (p *legacyParser) unterminated() error {
+	if err := p.s.Err(); err != nil {
+		return err
+	}
+	return errors.New("invalid format: unexpected end of file")
+}
+
+// ParseLegacyLibrary reads a footprint library in the legacy
+// (PCBNEW-LibModule-V1) format used by KiCad 4 and earlier, returning the
+// modules it contains in the order they appear.
+func ParseLegacyLibrary(r io.Reader) ([]*Module, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	p := &legacyParser{s: s, scale: legacyDecimil}
+	if !p.next() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("invalid format: empty file")
+	}
+	if !strings.HasPrefix(p.text, "PCBNEW-LibModule-V1") {
+		return nil, errors.New("invalid format: missing PCBNEW-LibModule-V1 header")
+	}
+
+	var out []*Module
+	for p.next() {
+		switch p.keyword() {
+		case "Units":
+			switch p.rest() {
+			case "mm":
+				p.scale = 1
+			default:
+				return nil, p.errorf("unsupported units %q", p.rest())
+			}
+		case "$INDEX":
+			for {
+				if !p.next() {
+					return nil, p.unterminated()
+				}
+				if p.text == "$EndINDEX" {
+					break
+				}
+			}
+		case "$MODULE":
+			m, err := p.parseModule(p.rest(), len(out))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, m)
+		case "$EndLIBRARY":
+			return out, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *legacyParser) parseModule(name string, ordering int) (*Module, error) {
+	m := Module{
+		Name:        name,
+		Layer:       "F.Cu",
+		ZoneConnect: ZoneConnectInherited,
+		order:       ordering,
+	}
+
+	for {
+		if !p.next() {
+			return nil, p.unterminated()
+		}
+		l := p.values()
+		switch kw := p.keyword(); kw {
+		case "$EndMODULE":
+			return &m, nil
+
+		case "Po":
+			m.Layer = l.layer(4)
+			if tedit := l.str(5); tedit != "00000000" {
+				m.Tedit = tedit
+			}
+		case "Li":
+			m.Name = p.rest()
+		case "Cd":
+			m.Description = p.rest()
+		case "Kw":
+			m.Tags = strings.Fields(p.rest())
+		case "At":
+			for _, a := range l.values[1:] {
+				switch a {
+				case "SMD":
+					m.Attrs = append(m.Attrs, "smd")
+				case "VIRTUAL":
+					m.Attrs = append(m.Attrs, "virtual")
+				}
+			}
+
+		case ".SolderMask":
+			m.SolderMaskMargin = l.dim(1)
+		case ".SolderPaste":
+			m.SolderPasteMargin = l.dim(1)
+		case ".SolderPasteRatio":
+			m.SolderPasteRatio = l.float(1)
+		case ".LocalClearance":
+			m.Clearance = l.dim(1)
+		case ".ZoneConnection":
+			m.ZoneConnect = ZoneConnectMode(l.float(1))
+
+		case "DS":
+			m.Graphics = append(m.Graphics, ModGraphic{
+				Ident: "fp_line",
+				Renderable: &ModLine{
+					Start: l.xy(1),
+					End:   l.xy(3),
+					Width: l.dim(5),
+					Layer: l.layer(6),
+				},
+			})
+		case "DC":
+			m.Graphics = append(m.Graphics, ModGraphic{
+				Ident: "fp_circle",
+				Renderable: &ModCircle{
+					Center: l.xy(1),
+					End:    l.xy(3),
+					Width:  l.dim(5),
+					Layer:  l.layer(6),
+				},
+			})
+		case "DA":
+			m.Graphics = append(m.Graphics, ModGraphic{
+				Ident: "fp_arc",
+				Renderable: &ModArc{
+					Start: l.xy(1),
+					End:   l.xy(3),
+					Angle: l.angle(5),
+					Width: l.dim(6),
+					Layer: l.layer(7),
+				},
+			})
+		case "DP":
+			poly := &ModPolygon{
+				Width: l.dim(6),
+				Layer: l.layer(7),
+			}
+			count := int(l.float(5))
+			for i := 0; i < count && l.err == nil; i++ {
+				if !p.next() {
+					return nil, p.unterminated()
+				}
+				if p.keyword() != "Dl" {
+					return nil, p.errorf("expected %d polygon points, got %d", count, i)
+				}
+				pt := p.values()
+				poly.Points = append(poly.Points, pt.xy(1))
+				if pt.err != nil {
+					return nil, p.errorf("%v", pt.err)
+				}
+			}
+			m.Graphics = append(m.Graphics, ModGraphic{Ident: "fp_poly", Renderable: poly})
+
+		case "$PAD":
+			pad, err := p.parsePad()
+			if err != nil {
+				return nil, err
+			}
+			m.Pads = append(m.Pads, *pad)
+		case "$SHAPE3D":
+			model, err := p.parseModel()
+			if err != nil {
+				return nil, err
+			}
+			m.Models = append(m.Models, *model)
+
+		default:
+			if len(kw) == 2 && kw[0] == 'T' && kw[1] >= '0' && kw[1] <= '9' {
+				t, err := p.parseText(l)
+				if err != nil {
+					return nil, err
+				}
+				m.Graphics = append(m.Graphics, ModGraphic{Ident: "fp_text", Renderable: t})
+			}
+			// Other lines, such as autoplace costs, are not represented.
+		}
+		if l.err != nil {
+			return nil, p.errorf("%v", l.err)
+		}
+	}
+}
+
+// parseText parses a text line, such as:
+// T0 0 -2500 600 600 0 120 N V 21 N "REF**"
+func (p *legacyParser) parseText(l *legacyLine) (*ModText, error) {
+	t := ModText{
+		At:    XYZ{X: l.dim(1), Y: l.dim(2), Z: l.angle(5)},
+		Layer: l.layer(9),
+		Effects: TextEffects{
+			FontSize:  l.xy(3),
+			Thickness: l.dim(6),
+		},
+		Hidden: l.str(8) == "I",
+		Text:   l.str(len(l.values) - 1),
+	}
+	if l.err != nil {
+		return nil, p.errorf("%v", l.err)
+	}
+	if len(l.values) < 11 {
+		return nil, p.errorf("%s: missing text", l.values[0])
+	}
+	t.At.ZPresent = t.At.Z != 0
+	// Text written by older versions has no italic flag.
+	t.Effects.Italic = len(l.values) > 11 && l.values[10] == "I"
+
+	switch l.values[0] {
+	case "T0":
+		t.Kind = RefText
+	case "T1":
+		t.Kind = ValueText
+	default:
+		t.Kind = UserText
+	}
+	return &t, nil
+}
+
+func (p *legacyParser) parsePad() (*Pad, error) {
+	pad := Pad{ZoneConnect: ZoneConnectInherited}
+	for {
+		if !p.next() {
+			return nil, p.unterminated()
+		}
+		l := p.values()
+		switch p.keyword() {
+		case "$EndPAD":
+			return &pad, nil
+
+		case "Sh":
+			pad.Ident = l.str(1)
+			switch l.str(2) {
+			case "R":
+				pad.Shape = ShapeRect
+			case "O":
+				pad.Shape = ShapeOval
+			case "C":
+				pad.Shape = ShapeCircle
+			case "T":
+				pad.Shape = ShapeTrapezoid
+			default:
+				return nil, p.errorf("unknown pad shape %q", l.str(2))
+			}
+			pad.Size = l.xy(3)
+			pad.RectDelta = l.xy(5)
+			pad.At.Z = l.angle(7)
+			pad.At.ZPresent = pad.At.Z != 0
+		case "Dr":
+			pad.DrillSize.X = l.dim(1)
+			pad.DrillOffset = l.xy(2)
+			if len(l.values) > 4 && l.values[4] == "O" {
+				pad.DrillShape = ShapeDrillOblong
+				pad.DrillSize = l.xy(5)
+			}
+		case "At":
+			switch l.str(1) {
+			case "STD":
+				pad.Surface = SurfaceTH
+			case "SMD":
+				pad.Surface = SurfaceSMD
+			case "CONN":
+				pad.Surface = SurfaceConnect
+			case "HOLE":
+				pad.Surface = SurfaceNPTH
+			default:
+				return nil, p.errorf("unknown pad type %q", l.str(1))
+			}
+			layers, err := legacyMaskLayers(l.str(3))
+			if err != nil && l.err == nil {
+				l.err = err
+			}
+			pad.Layers = layers
+		case "Ne":
+			pad.NetNum = int(l.float(1))
+			if len(l.values) > 2 {
+				pad.NetName = l.values[2]
+			}
+		case "Po":
+			xy := l.xy(1)
+			pad.At.X, pad.At.Y = xy.X, xy.Y
+		case "Le":
+			pad.DieLength = l.dim(1)
+
+		case ".SolderMask":
+			pad.SolderMaskMargin = l.dim(1)
+		case ".SolderPaste":
+			pad.SolderPasteMargin = l.dim(1)
+		case ".SolderPasteRatio":
+			pad.SolderPasteMarginRatio = l.float(1)
+		case ".LocalClearance":
+			pad.Clearance = l.dim(1)
+		case ".ZoneConnection":
+			pad.ZoneConnect = ZoneConnectMode(l.float(1))
+		case ".ThermalWidth":
+			pad.ThermalWidth = l.dim(1)
+		case ".ThermalGap":
+			pad.ThermalGap = l.dim(1)
+		}
+		if l.err != nil {
+			return nil, p.errorf("%v", l.err)
+		}
+	}
+}
+
+func (p *legacyParser) parseModel() (*ModModel, error) {
+	m := ModModel{}
+	xyz := func(l *legacyLine) XYZ {
+		return XYZ{X: l.float(1), Y: l.float(2), Z: l.float(3), ZPresent: true}
+	}
+	for {
+		if !p.next() {
+			return nil, p.unterminated()
+		}
+		l := p.values()
+		switch p.keyword() {
+		case "$EndSHAPE3D":
+			return &m, nil
+		case "Na":
+			m.Path = l.str(1)
+		case "Sc":
+			m.Scale = xyz(l)
+		case "Of":
+			// Offsets are in inches, as with the at expression of KiCad 4.
+			m.At = xyz(l)
+		case "Ro":
+			m.Rotate = xyz(l)
+		}
+		if l.err != nil {
+			return nil, p.errorf("%v", l.err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package pcb
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const legacyLibrary = `PCBNEW-LibModule-V1  Mon 08 Jul 2013 10:00:00 AM CEST
+# encoding utf-8
+$INDEX
+R_0805
+PIN_1
+$EndINDEX
+$MODULE R_0805
+Po 0 0 0 15 51D2B8E0 00000000 ~~
+Li R_0805
+Cd SMD resistor, 0805
+Kw R SMD 0805
+Sc 0
+AR
+Op 0 0 0
+At SMD
+T0 0 -1000 400 400 0 60 N V 21 N "R_0805"
+T1 0 1000 400 400 0 60 N I 21 N "VAL**"
+DS -1500 -600 1500 -600 60 21
+DC 0 0 200 0 50 21
+DA 0 0 500 0 900 50 21
+DP 0 0 0 0 3 0 21
+Dl -100 0
+Dl 100 0
+Dl 0 100
+$PAD
+Sh "1" R 500 600 0 0 0
+Dr 0 0 0
+At SMD N 00888000
+Ne 0 ""
+Po -750 0
+$EndPAD
+$SHAPE3D
+Na "smd/resistors/R0805.wrl"
+Sc 1 1 1
+Of 0 0 0
+Ro 0 0 0
+$EndSHAPE3D
+$EndMODULE R_0805
+$MODULE PIN_1
+Po 0 0 0 15 00000000 00000000 ~~
+Li PIN_1
+Sc 0
+Op 0 0 0
+T0 0 -1500 500 500 0 100 N V 21 N "PIN_1"
+T1 0 1500 500 500 0 100 N V 21 N "P***"
+$PAD
+Sh "1" C 1500 1500 0 0 900
+Dr 800 0 0 O 800 1000
+At STD N 00E0FFFF
+Ne 0 ""
+Po 0 0
+.SolderMask 20
+$EndPAD
+$EndMODULE PIN_1
+$EndLIBRARY
+`
+
+func TestParseLegacyLibrary(t *testing.T) {
+	mods, err := ParseLegacyLibrary(strings.NewReader(legacyLibrary))
+	if err != nil {
+		t.Fatalf("ParseLegacyLibrary() failed: %v", err)
+	}
+	if len(mods) != 2 {
+		t.Fatalf("got %d modules, want 2", len(mods))
+	}
+
+	want := &Module{
+		Name:        "R_0805",
+		Layer:       "F.Cu",
+		Tedit:       "51D2B8E0",
+		ZoneConnect: ZoneConnectInherited,
+		Description: "SMD resistor, 0805",
+		Tags:        []string{"R", "SMD", "0805"},
+		Attrs:       []string{"smd"},
+		Graphics: []ModGraphic{
+			{
+				Ident: "fp_text",
+				Renderable: &ModText{
+					Kind:    RefText,
+					Text:    "R_0805",
+					At:      XYZ{Y: -2.54},
+					Layer:   "F.SilkS",
+					Effects: TextEffects{FontSize: XY{X: 1.016, Y: 1.016}, Thickness: 0.1524},
+				},
+			},
+			{
+				Ident: "fp_text",
+				Renderable: &ModText{
+					Kind:    ValueText,
+					Hidden:  true,
+					Text:    "VAL**",
+					At:      XYZ{Y: 2.54},
+					Layer:   "F.SilkS",
+					Effects: TextEffects{FontSize: XY{X: 1.016, Y: 1.016}, Thickness: 0.1524},
+				},
+			},
+			{
+				Ident:      "fp_line",
+				Renderable: &ModLine{Start: XY{X: -3.81, Y: -1.524}, End: XY{X: 3.81, Y: -1.524}, Layer: "F.SilkS", Width: 0.1524},
+			},
+			{
+				Ident:      "fp_circle",
+				Renderable: &ModCircle{End: XY{X: 0.508}, Layer: "F.SilkS", Width: 0.127},
+			},
+			{
+				Ident:      "fp_arc",
+				Renderable: &ModArc{End: XY{X: 1.27}, Angle: 90, Layer: "F.SilkS", Width: 0.127},
+			},
+			{
+				Ident: "fp_poly",
+				Renderable: &ModPolygon{
+					Points: []XY{{X: -0.254}, {X: 0.254}, {Y: 0.254}},
+					Layer:  "F.SilkS",
+				},
+			},
+		},
+		Pads: []Pad{
+			{
+				Ident:       "1",
+				At:          XYZ{X: -1.905},
+				Size:        XY{X: 1.27, Y: 1.524},
+				Layers:      []string{"F.Cu", "F.Paste", "F.Mask"},
+				ZoneConnect: ZoneConnectInherited,
+				Surface:     SurfaceSMD,
+				Shape:       ShapeRect,
+			},
+		},
+		Models: []ModModel{
+			{
+				Path:   "smd/resistors/R0805.wrl",
+				At:     XYZ{ZPresent: true},
+				Scale:  XYZ{X: 1, Y: 1, Z: 1, ZPresent: true},
+				Rotate: XYZ{ZPresent: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(mods[0], want) {
+		t.Errorf("R_0805 = %+v\nwant %+v", mods[0], want)
+	}
+
+	wantPad := Pad{
+		Ident:            "1",
+		At:               XYZ{Z: 90, ZPresent: true},
+		Size:             XY{X: 3.81, Y: 3.81},
+		Layers:           []string{"*.Cu", "F.SilkS", "*.Mask"},
+		DrillSize:        XY{X: 2.032, Y: 2.54},
+		DrillShape:       ShapeDrillOblong,
+		ZoneConnect:      ZoneConnectInherited,
+		SolderMaskMargin: 0.0508,
+		Surface:          SurfaceTH,
+		Shape:            ShapeCircle,
+	}
+	if mods[1].Name != "PIN_1" || mods[1].Tedit != "" {
+		t.Errorf("PIN_1 name, tedit = %q, %q", mods[1].Name, mods[1].Tedit)
+	}
+	if len(mods[1].Pads) != 1 || !reflect.DeepEqual(mods[1].Pads[0], wantPad) {
+		t.Errorf("PIN_1 pads = %+v, want %+v", mods[1].Pads, wantPad)
+	}
+}
+
+func TestParseLegacyLibraryUnitsMM(t *testing.T) {
+	mods, err := ParseLegacyLibrary(strings.NewReader(`PCBNEW-LibModule-V1  Sat 22 Jun 2013 12:00:00 PM CEST
+Units mm
+$MODULE TP
+Po 0 0 0 15 00000000 00000000 ~~
+Li TP
+DS -1.5 0 1.5 0 0.15 21
+$EndMODULE TP
+$EndLIBRARY
+`))
+	if err != nil {
+		t.Fatalf("ParseLegacyLibrary() failed: %v", err)
+	}
+	if len(mods) != 1 || len(mods[0].Graphics) != 1 {
+		t.Fatalf("got %+v, want one module with one line", mods)
+	}
+	want := &ModLine{Start: XY{X: -1.5}, End: XY{X: 1.5}, Layer: "F.SilkS", Width: 0.15}
+	if got := mods[0].Graphics[0].Renderable; !reflect.DeepEqual(got, want) {
+		t.Errorf("line = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseLegacyLibraryRoundTrip(t *testing.T) {
+	mods, err := ParseLegacyLibrary(strings.NewReader(legacyLibrary))
+	if err != nil {
+		t.Fatalf("ParseLegacyLibrary() failed: %v", err)
+	}
+	for _, m := range mods {
+		var buf bytes.Buffer
+		if err := m.WriteModule(&buf); err != nil {
+			t.Fatalf("WriteModule(%q) failed: %v", m.Name, err)
+		}
+		got, err := ParseModule(strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatalf("ParseModule(%q) failed: %v\n%s", m.Name, err, buf.String())
+		}
+		if !reflect.DeepEqual(got.Pads, m.Pads) {
+			t.Errorf("%s: pads = %+v, want %+v", m.Name, got.Pads, m.Pads)
+		}
+		if !reflect.DeepEqual(got.Graphics, m.Graphics) {
+			t.Errorf("%s: graphics = %+v, want %+v", m.Name, got.Graphics, m.Graphics)
+		}
+	}
+}
+
+func TestParseLegacyLibraryMalformed(t *testing.T) {
+	tcs := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"missing header", "EESchema-LIBRARY Version 2.3\n"},
+		{"unterminated module", "PCBNEW-LibModule-V1\n$MODULE R\nPo 0 0 0 15 00000000 00000000 ~~\n"},
+		{"unterminated pad", "PCBNEW-LibModule-V1\n$MODULE R\n$PAD\nSh \"1\" R 500 600 0 0 0\n"},
+		{"bad number", "PCBNEW-LibModule-V1\n$MODULE R\nDS 0 0 x 0 60 21\n$EndMODULE R\n"},
+		{"bad layer", "PCBNEW-LibModule-V1\n$MODULE R\nDS 0 0 1 0 60 99\n$EndMODULE R\n"},
+		{"short line", "PCBNEW-LibModule-V1\n$MODULE R\nDC 0 0\n$EndMODULE R\n"},
+		{"missing polygon points", "PCBNEW-LibModule-V1\n$MODULE R\nDP 0 0 0 0 2 0 21\nDl 0 0\n$EndMODULE R\n"},
+		{"bad pad shape", "PCBNEW-LibModule-V1\n$MODULE R\n$PAD\nSh \"1\" X 500 600 0 0 0\n$EndPAD\n$EndMODULE R\n"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseLegacyLibrary(strings.NewReader(tc.input)); err == nil {
+				t.Error("ParseLegacyLibrary() succeeded, want error")
+			}
+		})
+	}
+}
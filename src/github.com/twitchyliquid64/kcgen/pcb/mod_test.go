@@ -285,6 +285,131 @@ func TestParseMod(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "kicad 6 footprint",
+			input: `
+(footprint "LED_D5.0mm" (version 20211014) (generator pcbnew)
+  (layer "F.Cu")
+  (tedit 5995936A)
+  (attr through_hole)
+  (fp_rect (start -1.95 -3.25) (end 4.5 3.25) (layer "F.CrtYd") (width 0.05) (fill none) (tstamp 7d0f5b4c-1a6e-4c0d-e5f2-4b8a0c6d1e66))
+  (fp_arc (start -2 0) (mid 0 -2) (end 2 0) (layer "F.Fab") (width 0.1) (tstamp 8e1a6c5d-2b7f-4d1e-f6a3-5c9b1d7e2f77))
+  (fp_arc (start 0 5) (mid 3 4) (end 5 0) (layer "F.Fab") (width 0.1))
+)
+    `,
+			expected: Module{
+				Name:        "LED_D5.0mm",
+				Version:     20211014,
+				Generator:   "pcbnew",
+				ZoneConnect: ZoneConnectInherited,
+				Layer:       "F.Cu",
+				Tedit:       "5995936A",
+				Attrs:       []string{"through_hole"},
+				Graphics: []ModGraphic{
+					{
+						Ident: "fp_rect",
+						Renderable: &ModRect{
+							Start: XY{X: -1.95, Y: -3.25},
+							End:   XY{X: 4.5, Y: 3.25},
+							Layer: "F.CrtYd",
+							Width: 0.05,
+						},
+					},
+					{
+						Ident: "fp_arc",
+						Renderable: &ModArc{
+							Start: XY{},
+							End:   XY{X: -2},
+							Angle: 180,
+							Layer: "F.Fab",
+							Width: 0.1,
+						},
+					},
+					{
+						Ident: "fp_arc",
+						Renderable: &ModArc{
+							Start: XY{},
+							End:   XY{Y: 5},
+							Angle: -90,
+							Layer: "F.Fab",
+							Width: 0.1,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "kicad 8 footprint",
+			input: `
+(footprint "TestPoint_Pad_D1.0mm"
+	(version 20240108)
+	(generator "pcbnew")
+	(generator_version "8.0")
+	(layer "F.Cu")
+	(property "Reference" "REF**"
+		(at 0 -1.448 0)
+		(layer "F.SilkS")
+		(uuid "3f2c9d86-0b71-4a5e-9c44-55a1e2f7b101")
+		(effects (font (size 1 1) (thickness 0.15) (bold yes)))
+	)
+	(property "Datasheet" ""
+		(at 0 0 0)
+		(unlocked yes)
+		(layer "F.Fab")
+		(hide yes)
+		(uuid "2b3c4d5e-6f70-4182-93a4-b5c6d7e8f904")
+		(effects (font (size 1.27 1.27) (thickness 0.15)))
+	)
+	(attr smd exclude_from_pos_files)
+	(fp_circle (center 0 0) (end 0 0.7) (stroke (width 0.12) (type solid)) (fill yes) (layer "F.SilkS") (uuid "4d5e6f70-8192-43a4-b5c6-d7e8f90a1b06"))
+	(fp_curve (pts (xy -0.5 1) (xy -0.2 1.3) (xy 0.2 1.3) (xy 0.5 1)) (stroke (width 0.12) (type solid)) (layer "F.SilkS"))
+	(group "" (uuid "92a3b4c5-d6e7-48f9-0a1b-2c3d4e5f6a0b") (members "4d5e6f70-8192-43a4-b5c6-d7e8f90a1b06"))
+	(embedded_fonts no)
+)
+    `,
+			expected: Module{
+				Name:        "TestPoint_Pad_D1.0mm",
+				Version:     20240108,
+				Generator:   "pcbnew",
+				ZoneConnect: ZoneConnectInherited,
+				Layer:       "F.Cu",
+				Attrs:       []string{"smd", "exclude_from_pos_files"},
+				Properties:  []ModProperty{{Name: "Datasheet"}},
+				Graphics: []ModGraphic{
+					{
+						Ident: "fp_text",
+						Renderable: &ModText{
+							Kind:  RefText,
+							Text:  "REF**",
+							At:    XYZ{Y: -1.448, ZPresent: true},
+							Layer: "F.SilkS",
+							Effects: TextEffects{
+								Thickness: 0.15,
+								FontSize:  XY{X: 1, Y: 1},
+								Bold:      true,
+							},
+						},
+					},
+					{
+						Ident: "fp_circle",
+						Renderable: &ModCircle{
+							End:   XY{Y: 0.7},
+							Layer: "F.SilkS",
+							Width: 0.12,
+							Fill:  true,
+						},
+					},
+					{
+						Ident: "fp_curve",
+						Renderable: &ModCurve{
+							Points: []XY{{-0.5, 1}, {-0.2, 1.3}, {0.2, 1.3}, {0.5, 1}},
+							Layer:  "F.SilkS",
+							Width:  0.12,
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -299,3 +424,47 @@ func TestParseMod(t *testing.T) {
 		})
 	}
 }
+
+func TestParseModPolygonArc(t *testing.T) {
+	mod, err := ParseModule(strings.NewReader(`
+(footprint "Fiducial_1mm_Mask2mm" (version 20221018) (generator pcbnew)
+  (layer "F.Cu")
+  (fp_text_box "Keep clear" (start -1.5 -4) (end 1.5 -2.8) (layer "Cmts.User")
+    (effects (font (size 0.4 0.4) (thickness 0.06)))
+    (stroke (width 0.1) (type solid)))
+  (dimension (type aligned) (layer "Cmts.User")
+    (pts (xy -1 3) (xy 1 3))
+    (height 1)
+    (gr_text "2.0000 mm" (at 0 2.85) (layer "Cmts.User")
+      (effects (font (size 0.4 0.4) (thickness 0.06)))))
+  (fp_poly
+    (pts
+      (xy -1 1)
+      (xy -1 -1)
+      (arc (start -1 -1) (mid 0 -2) (end 1 -1))
+      (xy 1 1)
+    )
+    (stroke (width 0.1) (type solid)) (fill none) (layer "F.Fab"))
+)`))
+	if err != nil {
+		t.Fatalf("ParseModule() failed: %v", err)
+	}
+	if len(mod.Graphics) != 1 {
+		t.Fatalf("len(mod.Graphics) = %d, want 1", len(mod.Graphics))
+	}
+	poly, ok := mod.Graphics[0].Renderable.(*ModPolygon)
+	if !ok {
+		t.Fatalf("mod.Graphics[0] = %+v, want a polygon", mod.Graphics[0])
+	}
+	// The 180 degree arc is approximated by 18 segments, whose first point
+	// is the polygon's second point.
+	pts := poly.Points
+	if got, want := len(pts), 21; got != want {
+		t.Fatalf("len(poly.Points) = %d, want %d: %+v", got, want, pts)
+	}
+	for i, want := range map[int]XY{1: {-1, -1}, 10: {0, -2}, 19: {1, -1}, 20: {1, 1}} {
+		if pts[i] != want {
+			t.Errorf("poly.Points[%d] = %+v, want %+v", i, pts[i], want)
+		}
+	}
+}
@@ -100,6 +100,16 @@ func (m *Module) write(sw *swriter.SExpWriter, doPlacement bool) error {
 		}
 	}
 
+	for _, p := range m.Properties {
+		sw.StartList(true)
+		sw.StringScalar("property")
+		sw.StringScalar(p.Name)
+		sw.StringScalar(p.Value)
+		if err := sw.CloseList(false); err != nil {
+			return err
+		}
+	}
+
 	for _, g := range m.Graphics {
 		if err := g.Renderable.write(sw, g.Ident); err != nil {
 			return err
@@ -112,6 +122,13 @@ func (m *Module) write(sw *swriter.SExpWriter, doPlacement bool) error {
 		}
 	}
 
+	for _, z := range m.Zones {
+		sw.Newlines(1)
+		if err := z.write(sw); err != nil {
+			return err
+		}
+	}
+
 	for _, model := range m.Models {
 		sw.StartList(true)
 		sw.StringScalar("model")
@@ -255,6 +272,89 @@ func (c *ModCircle) write(sw *swriter.SExpWriter, ident string) error {
 		return err
 	}
 
+	if c.Fill {
+		if err := writeFill(sw); err != nil {
+			return err
+		}
+	}
+
+	return sw.CloseList(false)
+}
+
+func (r *ModRect) write(sw *swriter.SExpWriter, ident string) error {
+	sw.StartList(true)
+	sw.StringScalar(ident)
+	if err := r.Start.write("start", sw); err != nil {
+		return err
+	}
+	if err := r.End.write("end", sw); err != nil {
+		return err
+	}
+
+	if r.Layer != "" {
+		sw.StartList(false)
+		sw.StringScalar("layer")
+		sw.StringScalar(r.Layer)
+		if err := sw.CloseList(false); err != nil {
+			return err
+		}
+	}
+
+	sw.StartList(false)
+	sw.StringScalar("width")
+	sw.StringScalar(f(r.Width))
+	if err := sw.CloseList(false); err != nil {
+		return err
+	}
+
+	if r.Fill {
+		if err := writeFill(sw); err != nil {
+			return err
+		}
+	}
+
+	return sw.CloseList(false)
+}
+
+func (b *ModCurve) write(sw *swriter.SExpWriter, ident string) error {
+	sw.StartList(true)
+	sw.StringScalar(ident)
+
+	sw.StartList(false)
+	sw.StringScalar("pts")
+	for _, pts := range b.Points {
+		if err := pts.write("xy", sw); err != nil {
+			return err
+		}
+	}
+	if err := sw.CloseList(false); err != nil {
+		return err
+	}
+
+	if b.Layer != "" {
+		sw.StartList(false)
+		sw.StringScalar("layer")
+		sw.StringScalar(b.Layer)
+		if err := sw.CloseList(false); err != nil {
+			return err
+		}
+	}
+
+	sw.StartList(false)
+	sw.StringScalar("width")
+	sw.StringScalar(f(b.Width))
+	if err := sw.CloseList(false); err != nil {
+		return err
+	}
+
+	return sw.CloseList(false)
+}
+
+// writeFill writes the fill expression of a filled shape.
+func writeFill(sw *swriter.SExpWriter) error {
+	sw.StartList(false)
+	sw.StringScalar("fill")
+	sw.StringScalar("solid")
 	return sw.CloseList(false)
 }
 
@@ -322,7 +322,8 @@ func DecodeFile(fpath string) (*PCB, error) {
 				}
 				pcb.NetClasses = append(pcb.NetClasses, *c)
 
-			case "module":
+			case "module", "footprint":
+				// KiCad 6+ boards place footprints rather than modules.
 				m, err := parseModule(n, ordering)
 				if err != nil {
 					return nil, err
@@ -157,3 +157,34 @@ func TestPCB(t *testing.T) {
 		t.Errorf("p.Drawings[0].Features[1].Feature = %v, want %v", got, want)
 	}
 }
+
+func TestPCBKicad6Footprints(t *testing.T) {
+	p, err := DecodeFile("testdata/kicad6.kicad_pcb")
+	if err != nil {
+		t.Fatalf("DecodeFile() failed: %v", err)
+	}
+
+	if got, want := p.FormatVersion, 20211014; got != want {
+		t.Errorf("p.FormatVersion = %v, want %v", got, want)
+	}
+	if got, want := len(p.Modules), 2; got != want {
+		t.Fatalf("len(p.Modules) = %v, want %v", got, want)
+	}
+
+	m := p.Modules[0]
+	if got, want := m.Name, "Resistor_SMD:R_0805_2012Metric"; got != want {
+		t.Errorf("m.Name = %q, want %q", got, want)
+	}
+	if got, want := m.Placement.At, (XYZ{X: 100, Y: 50, Z: 90, ZPresent: true}); got != want {
+		t.Errorf("m.Placement.At = %+v, want %+v", got, want)
+	}
+	if got, want := len(m.Pads), 2; got != want {
+		t.Fatalf("len(m.Pads) = %v, want %v", got, want)
+	}
+	if got, want := m.Pads[1].NetName, "VCC"; got != want {
+		t.Errorf("m.Pads[1].NetName = %q, want %q", got, want)
+	}
+	if got, want := p.Modules[1].Layer, "B.Cu"; got != want {
+		t.Errorf("p.Modules[1].Layer = %q, want %q", got, want)
+	}
+}
@@ -1,8 +1,18 @@
 package admin
 
+import (
+  "net/http"
+)
+
 var adminSecret = ""
 
 // SetSecret sets the password for admin RPCs.
 func SetSecret(s string) {
   adminSecret = s
 }
+
+// Authorized returns true if the request carries the admin secret, entitling
+// it to see private sources and their parts.
+func Authorized(req *http.Request) bool {
+  return adminSecret != "" && req.FormValue("secret") == adminSecret
+}
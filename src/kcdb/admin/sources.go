@@ -1,11 +1,16 @@
 package admin
 
 import (
+  "encoding/json"
   "net/http"
+  "os"
   "strconv"
+  "strings"
   "fmt"
 
+  "kcdb/creds"
   "kcdb/db"
+  "kcdb/ingestor"
 )
 
 // UpdateSourceAdmin is called to set the tag & rank of a source.
@@ -55,3 +60,341 @@ func AddSourceAdmin(w http.ResponseWriter, req *http.Request) {
   }
   w.Write([]byte("OK."))
 }
+
+// IngestSourceAdmin is called to ingest a source as soon as possible.
+func IngestSourceAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  if _, err := db.GetSource(req.Context(), uid, db.DB()); err != nil {
+    if err == os.ErrNotExist {
+      http.Error(w, "Not Found", http.StatusNotFound)
+    } else {
+      http.Error(w, "Internal error", http.StatusInternalServerError)
+    }
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  ingestor.Enqueue(uid)
+  w.Write([]byte("OK."))
+}
+
+// WebhookSecretAdmin is called to set the secret which authenticates push
+// webhooks for a source. An empty secret disables webhooks for the source.
+func WebhookSecretAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  err = db.SetSourceWebhookSecret(req.Context(), uid, req.FormValue("webhook_secret"), db.DB())
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Write([]byte("OK."))
+}
+
+// DisableSourceAdmin is called to disable or re-enable scheduled ingests of a source.
+func DisableSourceAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  disabled, err := strconv.ParseBool(req.FormValue("disabled"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  err = db.SetSourceDisabled(req.Context(), uid, disabled, db.DB())
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Write([]byte("OK."))
+}
+
+// CredentialsAdmin is called to set the credentials used to access a private
+// source. An empty kind removes any credentials from the source.
+func CredentialsAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+
+  var sealed string
+  if kind := req.FormValue("kind"); kind != "" {
+    c := &creds.Credentials{
+      Kind:       kind,
+      Username:   req.FormValue("username"),
+      Password:   req.FormValue("password"),
+      PrivateKey: req.FormValue("private_key"),
+      Passphrase: req.FormValue("passphrase"),
+    }
+    if err := c.Validate(); err != nil {
+      http.Error(w, "Bad credentials: "+err.Error(), http.StatusBadRequest)
+      return
+    }
+    if sealed, err = creds.Seal(c); err != nil {
+      http.Error(w, "Internal error", http.StatusInternalServerError)
+      fmt.Printf("Err: %v\n", err)
+      return
+    }
+  }
+  err = db.SetSourceCredentials(req.Context(), uid, sealed, db.DB())
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Write([]byte("OK."))
+}
+
+// ConfigureSourceAdmin is called to set the ingest settings of a source.
+func ConfigureSourceAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  meta := db.SourceMetadata{
+    Ref:     req.FormValue("ref"),
+    Subdir:  req.FormValue("subdir"),
+    Include: splitPatterns(req.FormValue("include")),
+    Exclude: splitPatterns(req.FormValue("exclude")),
+  }
+  if err := meta.Validate(); err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  b, err := json.Marshal(meta)
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  err = db.SetSourceMetadata(req.Context(), uid, string(b), db.DB())
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Write([]byte("OK."))
+}
+
+// splitPatterns splits a comma-separated list of glob patterns.
+func splitPatterns(s string) []string {
+  var out []string
+  for _, p := range strings.Split(s, ",") {
+    if p = strings.TrimSpace(p); p != "" {
+      out = append(out, p)
+    }
+  }
+  return out
+}
+
+// SourceCandidatesAdmin lists repositories referenced by the library tables of
+// sources, which are not yet sources themselves.
+func SourceCandidatesAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  candidates, err := db.GetSourceCandidates(req.Context(), db.DB())
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  b, err := json.Marshal(candidates)
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(b)
+}
+
+// PromoteCandidateAdmin is called to add a candidate repository as a git
+// source, with an optional tag & rank. The new source is ingested as soon as
+// possible.
+func PromoteCandidateAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  var rank int
+  if r := req.FormValue("rank"); r != "" {
+    if rank, err = strconv.Atoi(r); err != nil {
+      http.Error(w, "Bad request", http.StatusBadRequest)
+      fmt.Printf("Err: %v\n", err)
+      return
+    }
+  }
+  sourceUID, err := db.PromoteSourceCandidate(req.Context(), uid, rank, req.FormValue("tag"), db.DB())
+  if err != nil {
+    if err == os.ErrNotExist {
+      http.Error(w, "Not Found", http.StatusNotFound)
+    } else {
+      http.Error(w, "Internal error", http.StatusInternalServerError)
+    }
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  ingestor.Enqueue(sourceUID)
+  w.Write([]byte("OK."))
+}
+
+// SuggestionsAdmin lists the repositories which users have suggested as
+// sources. If status is given, only suggestions with that status are listed.
+func SuggestionsAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  suggestions, err := db.GetSourceSuggestions(req.Context(), req.FormValue("status"), db.DB())
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  b, err := json.Marshal(suggestions)
+  if err != nil {
+    http.Error(w, "Internal error", http.StatusInternalServerError)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(b)
+}
+
+// ApproveSuggestionAdmin is called to add a suggested repository as a git
+// source with the given tag & rank. The new source is ingested as soon as
+// possible.
+func ApproveSuggestionAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  var rank int
+  if r := req.FormValue("rank"); r != "" {
+    if rank, err = strconv.Atoi(r); err != nil {
+      http.Error(w, "Bad request", http.StatusBadRequest)
+      fmt.Printf("Err: %v\n", err)
+      return
+    }
+  }
+  sourceUID, err := db.ApproveSourceSuggestion(req.Context(), uid, rank, req.FormValue("tag"), db.DB())
+  if err != nil {
+    if err == os.ErrNotExist {
+      http.Error(w, "Not Found", http.StatusNotFound)
+    } else {
+      http.Error(w, "Internal error", http.StatusInternalServerError)
+    }
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  ingestor.Enqueue(sourceUID)
+  w.Write([]byte("OK."))
+}
+
+// RejectSuggestionAdmin is called to reject a suggested repository, recording
+// the reason.
+func RejectSuggestionAdmin(w http.ResponseWriter, req *http.Request) {
+  if adminSecret == "" {
+    return
+  }
+  if req.FormValue("secret") != adminSecret {
+    http.Error(w, "Not Authorized", http.StatusUnauthorized)
+    return
+  }
+  uid, err := strconv.Atoi(req.FormValue("uid"))
+  if err != nil {
+    http.Error(w, "Bad request", http.StatusBadRequest)
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  err = db.RejectSourceSuggestion(req.Context(), uid, req.FormValue("reason"), db.DB())
+  if err != nil {
+    if err == os.ErrNotExist {
+      http.Error(w, "Not Found", http.StatusNotFound)
+    } else {
+      http.Error(w, "Internal error", http.StatusInternalServerError)
+    }
+    fmt.Printf("Err: %v\n", err)
+    return
+  }
+  w.Write([]byte("OK."))
+}
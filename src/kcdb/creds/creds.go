@@ -0,0 +1,144 @@
+// Package creds encrypts the credentials used to access private sources, so
+// they are never stored in plain text.
+package creds
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Credential kinds.
+const (
+	KindBasic = "basic"
+	KindSSH   = "ssh"
+)
+
+// ErrNoKey is returned if credentials are used before a key is configured.
+var ErrNoKey = errors.New("no credentials key is configured")
+
+// Credentials authenticate access to a source.
+type Credentials struct {
+	Kind string `json:"kind"`
+	// Username and Password are used for HTTP basic authentication. The
+	// password is typically an access token.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// PrivateKey is a PEM encoded SSH private key, which may be protected by
+	// Passphrase.
+	PrivateKey string `json:"private_key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// Validate returns an error if the credentials are incomplete.
+func (c *Credentials) Validate() error {
+	switch c.Kind {
+	case KindBasic:
+		if c.Password == "" {
+			return errors.New("a password or token is required")
+		}
+	case KindSSH:
+		if c.PrivateKey == "" {
+			return errors.New("a private key is required")
+		}
+	default:
+		return fmt.Errorf("unknown credential kind %q", c.Kind)
+	}
+	return nil
+}
+
+// String implements fmt.Stringer, omitting any secrets.
+func (c *Credentials) String() string {
+	return fmt.Sprintf("%s credentials for %q", c.Kind, c.Username)
+}
+
+// Box encrypts and decrypts credentials.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a Box which encrypts with a key derived from the given secret.
+func NewBox(secret string) (*Box, error) {
+	if secret == "" {
+		return nil, ErrNoKey
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts the credentials, returning them in a form suitable for storage.
+func (b *Box) Seal(c *Credentials) (string, error) {
+	pt, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, pt, nil)), nil
+}
+
+// Open decrypts credentials returned by Seal.
+func (b *Box) Open(sealed string) (*Credentials, error) {
+	ct, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(ct) < b.aead.NonceSize() {
+		return nil, errors.New("sealed credentials are truncated")
+	}
+	pt, err := b.aead.Open(nil, ct[:b.aead.NonceSize()], ct[b.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("could not decrypt credentials: wrong key or corrupt data")
+	}
+	var c Credentials
+	return &c, json.Unmarshal(pt, &c)
+}
+
+var box *Box
+
+// SetKey configures the secret used to encrypt credentials. Credentials
+// cannot be used if no key is set.
+func SetKey(secret string) error {
+	if secret == "" {
+		box = nil
+		return nil
+	}
+	b, err := NewBox(secret)
+	if err != nil {
+		return err
+	}
+	box = b
+	return nil
+}
+
+// Seal encrypts credentials with the configured key.
+func Seal(c *Credentials) (string, error) {
+	if box == nil {
+		return "", ErrNoKey
+	}
+	return box.Seal(c)
+}
+
+// Open decrypts credentials with the configured key.
+func Open(sealed string) (*Credentials, error) {
+	if box == nil {
+		return nil, ErrNoKey
+	}
+	return box.Open(sealed)
+}
@@ -22,6 +22,12 @@ var tables = []DatabaseTable{
 	&SourceTable{},
 	&FootprintTable{},
 	&SymbolTable{},
+	&SourceFileTable{},
+	&RevisionTable{},
+	&IngestErrorTable{},
+	&SourceCandidateTable{},
+	&SourceSuggestionTable{},
+	&PartProviderTable{},
 }
 
 // Init is called with database information to initialise a database session, creating any necessary tables.
@@ -54,6 +60,8 @@ func DB() *sql.DB {
 
 // Vacuum reclaims space in the database.
 func Vacuum(db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
 	_, err := db.Exec("VACUUM;")
 	return err
 }
@@ -28,7 +28,9 @@ func (t *FootprintTable) Setup(ctx context.Context, db *sql.DB) error {
 			pin_count INT NOT NULL,
 			attr VARCHAR(32) NOT NULL,
 			tags VARCHAR(256) NOT NULL,
-      data BLOB NOT NULL
+      data BLOB NOT NULL,
+			content_hash VARCHAR(64) NOT NULL DEFAULT '',
+			board VARCHAR(1024) NOT NULL DEFAULT ''
   	);
     CREATE UNIQUE INDEX IF NOT EXISTS footprints_url ON footprints(url);
 	`)
@@ -38,7 +40,40 @@ func (t *FootprintTable) Setup(ctx context.Context, db *sql.DB) error {
 	if err = tx.Commit(); err != nil {
 		return err
 	}
-	return nil
+	if err := t.migratev1(ctx, db); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+    CREATE INDEX IF NOT EXISTS footprints_content_hash ON footprints(content_hash);`)
+	return err
+}
+
+func (t *FootprintTable) migratev1(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT content_hash FROM footprints LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE footprints
+		ADD COLUMN content_hash VARCHAR(64) NOT NULL DEFAULT '';`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE footprints
+		ADD COLUMN board VARCHAR(1024) NOT NULL DEFAULT '';`)
+	if err != nil {
+		return err
+	}
+	// Existing footprints have no content hash until they are ingested again.
+	if err := forceReingest(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // Footprint contains information about a footprint.
@@ -54,8 +89,24 @@ type Footprint struct {
 	Attr     string `json:"attr"`
 	Tags     string `json:"tags"`
 
+	// ContentHash identifies the geometry of the footprint, ignoring where it
+	// was placed or when it was last edited.
+	ContentHash string `json:"content_hash,omitempty"`
+	// Board is the path of the board file the footprint was extracted from,
+	// or empty if the footprint came from a library.
+	Board string `json:"board,omitempty"`
+
+	// Not stored in DB
+	// AlsoFoundIn is the number of other sources with an identical footprint.
+	AlsoFoundIn int `json:"also_found_in,omitempty"`
+
 	// Not stored in DB
-	Rank int `json:"rank,omitempty"`
+	Rank    int  `json:"rank,omitempty"`
+	Private bool `json:"private,omitempty"`
+	// Commit and CommitDate describe where the data was ingested from. They
+	// are recorded against a new revision when the data changes.
+	Commit     string    `json:"-"`
+	CommitDate time.Time `json:"-"`
 }
 
 // MakePartURL creates a pretty URL for the footprint.
@@ -100,8 +151,13 @@ func UpdateFootprint(ctx context.Context, fp *Footprint, db *sql.DB) error {
 		return err
 	}
 
+	if err := recordRevision(ctx, tx, "footprint_revisions", "footprints", fp.UID, fp.Data, fp.Commit, fp.CommitDate); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	_, err = tx.ExecContext(ctx, `
-    UPDATE footprints SET data=?, pin_count=?, name=?, attr=?, tags=?, updated_at=CURRENT_TIMESTAMP WHERE rowid = ?;`, fp.Data, fp.PinCount, fp.Name, fp.Attr, fp.Tags, fp.UID)
+    UPDATE footprints SET data=?, pin_count=?, name=?, attr=?, tags=?, content_hash=?, board=?, updated_at=CURRENT_TIMESTAMP WHERE rowid = ?;`, fp.Data, fp.PinCount, fp.Name, fp.Attr, fp.Tags, fp.ContentHash, fp.Board, fp.UID)
 	if err != nil {
 		return err
 	}
@@ -119,29 +175,145 @@ func CreateFootprint(ctx context.Context, fp *Footprint, db *sql.DB) (int, error
 	}
 	e, err := tx.ExecContext(ctx, `
     INSERT INTO
-      footprints (source_id, url, data, name, pin_count, attr, tags)
-      VALUES (?, ?, ?, ?, ?, ?, ?);`, fp.SourceID, fp.URL, fp.Data, fp.Name, fp.PinCount, fp.Attr, fp.Tags)
+      footprints (source_id, url, data, name, pin_count, attr, tags, content_hash, board)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, fp.SourceID, fp.URL, fp.Data, fp.Name, fp.PinCount, fp.Attr, fp.Tags, fp.ContentHash, fp.Board)
 	if err != nil {
 		return 0, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return 0, err
-	}
 	id, err := e.LastInsertId()
 	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := recordRevision(ctx, tx, "footprint_revisions", "footprints", int(id), fp.Data, fp.Commit, fp.CommitDate); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return 0, err
 	}
 	return int(id), nil
 }
 
+// LibraryFootprintExists returns true if a footprint with the given content hash
+// exists which was not extracted from a board.
+func LibraryFootprintExists(ctx context.Context, contentHash string, db *sql.DB) (bool, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT rowid FROM footprints WHERE content_hash = ? AND board = '' LIMIT 1;
+  `, contentHash)
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+	return res.Next(), nil
+}
+
+// FootprintHashSources returns the number of distinct public sources which
+// contain a footprint with each of the given content hashes.
+func FootprintHashSources(ctx context.Context, hashes []string, db *sql.DB) (map[string]int, error) {
+	return hashSources(ctx, "footprints", hashes, db)
+}
+
+func hashSources(ctx context.Context, table string, hashes []string, db *sql.DB) (map[string]int, error) {
+	out := map[string]int{}
+	if len(hashes) == 0 {
+		return out, nil
+	}
+	params := make([]interface{}, len(hashes))
+	for i, h := range hashes {
+		params[i] = h
+	}
+
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	// Private sources are not counted, as their parts are hidden from others.
+	res, err := db.QueryContext(ctx, "SELECT p.content_hash, COUNT(DISTINCT p.source_id) FROM "+table+
+		" p JOIN sources s ON s.rowid = p.source_id WHERE s.credentials = '' AND p.content_hash IN (?"+
+		strings.Repeat(", ?", len(hashes)-1)+") GROUP BY p.content_hash;", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var hash string
+		var count int
+		if err := res.Scan(&hash, &count); err != nil {
+			return nil, err
+		}
+		out[hash] = count
+	}
+	return out, nil
+}
+
+// FootprintURLsBySource returns the URL and UID of every footprint from the given source.
+func FootprintURLsBySource(ctx context.Context, sourceUID int, db *sql.DB) (map[string]int, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT rowid, url FROM footprints WHERE source_id = ?;
+  `, sourceUID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := map[string]int{}
+	for res.Next() {
+		var uid int
+		var url string
+		if err := res.Scan(&uid, &url); err != nil {
+			return nil, err
+		}
+		out[url] = uid
+	}
+	return out, nil
+}
+
+// SetFootprintSource attributes the footprint with the given UID to another source.
+func SetFootprintSource(ctx context.Context, uid, sourceUID int, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	_, err := db.ExecContext(ctx, `UPDATE footprints SET source_id = ? WHERE rowid = ?;`, sourceUID, uid)
+	return err
+}
+
+// DeleteFootprints removes the footprints with the given UIDs, along with their revisions.
+func DeleteFootprints(ctx context.Context, uids []int, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM footprints WHERE rowid = ?;`, uid); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM footprint_revisions WHERE part_id = ?;`, uid); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // FootprintByURL returns the specified footprint
 func FootprintByURL(ctx context.Context, url string, db *sql.DB) (*Footprint, error) {
 	dbLock.RLock()
 	defer dbLock.RUnlock()
 
 	res, err := db.QueryContext(ctx, `
-    SELECT rowid, source_id, updated_at, url, data, name, pin_count, attr, tags FROM footprints WHERE url = ?;
+    SELECT rowid, source_id, updated_at, url, data, name, pin_count, attr, tags, content_hash, board FROM footprints WHERE url = ?;
   `, url)
 	if err != nil {
 		return nil, err
@@ -151,7 +323,7 @@ func FootprintByURL(ctx context.Context, url string, db *sql.DB) (*Footprint, er
 		return nil, os.ErrNotExist
 	}
 	var fp Footprint
-	return &fp, res.Scan(&fp.UID, &fp.SourceID, &fp.UpdatedAt, &fp.URL, &fp.Data, &fp.Name, &fp.PinCount, &fp.Attr, &fp.Tags)
+	return &fp, res.Scan(&fp.UID, &fp.SourceID, &fp.UpdatedAt, &fp.URL, &fp.Data, &fp.Name, &fp.PinCount, &fp.Attr, &fp.Tags, &fp.ContentHash, &fp.Board)
 }
 
 // FpSearchParam specifies parameters to constrain a footprint search.
@@ -159,6 +331,15 @@ type FpSearchParam struct {
 	Keywords []string
 	PinCount int
 	Attr     string
+	// Limit is the maximum number of results, or 65 if zero.
+	Limit int
+}
+
+func searchLimit(limit int) int {
+	if limit <= 0 {
+		return 65
+	}
+	return limit
 }
 
 // FootprintSearch performs a footprint search
@@ -184,7 +365,7 @@ func FootprintSearch(ctx context.Context, search FpSearchParam, db *sql.DB) ([]*
 	dbLock.RLock()
 	defer dbLock.RUnlock()
 
-	res, err := db.QueryContext(ctx, "SELECT rowid, source_id, updated_at, url, name, pin_count, attr, tags FROM footprints WHERE "+where+" LIMIT 65;", params...)
+	res, err := db.QueryContext(ctx, "SELECT rowid, source_id, updated_at, url, name, pin_count, attr, tags, board, content_hash FROM footprints WHERE "+where+" LIMIT ?;", append(params, searchLimit(search.Limit))...)
 	if err != nil {
 		fmt.Printf("db.QueryContext(%q) failed: %v\n", "... WHERE "+where, err)
 		return nil, err
@@ -194,7 +375,7 @@ func FootprintSearch(ctx context.Context, search FpSearchParam, db *sql.DB) ([]*
 	var out []*Footprint
 	for res.Next() {
 		var fp Footprint
-		if err := res.Scan(&fp.UID, &fp.SourceID, &fp.UpdatedAt, &fp.URL, &fp.Name, &fp.PinCount, &fp.Attr, &fp.Tags); err != nil {
+		if err := res.Scan(&fp.UID, &fp.SourceID, &fp.UpdatedAt, &fp.URL, &fp.Name, &fp.PinCount, &fp.Attr, &fp.Tags, &fp.Board, &fp.ContentHash); err != nil {
 			fmt.Printf("db.Scan(%q) failed: %v\n", "... WHERE "+where, err)
 			return nil, err
 		}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// IngestErrorTable records files in sources which could not be ingested.
+type IngestErrorTable struct{}
+
+// Setup is called on initialization to create necessary structures in the database.
+func (t *IngestErrorTable) Setup(ctx context.Context, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+  	CREATE TABLE IF NOT EXISTS ingest_errors (
+  		rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+  	  source_id INT NOT NULL,
+      path VARCHAR(1024) NOT NULL,
+			message TEXT NOT NULL,
+			commit_hash VARCHAR(64) NOT NULL DEFAULT '',
+  	  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+  	);
+    CREATE UNIQUE INDEX IF NOT EXISTS ingest_errors_path ON ingest_errors(source_id, path);
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IngestError describes a file which could not be ingested.
+type IngestError struct {
+	UID       int       `json:"uid"`
+	SourceID  int       `json:"source_uid"`
+	Path      string    `json:"path"`
+	Message   string    `json:"message"`
+	Commit    string    `json:"commit"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetIngestErrors returns the files in a source which could not be ingested.
+func GetIngestErrors(ctx context.Context, sourceUID int, db *sql.DB) ([]*IngestError, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+		SELECT rowid, source_id, path, message, commit_hash, created_at FROM ingest_errors WHERE source_id = ? ORDER BY path;
+	`, sourceUID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var out []*IngestError
+	for res.Next() {
+		var e IngestError
+		if err := res.Scan(&e.UID, &e.SourceID, &e.Path, &e.Message, &e.Commit, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, &e)
+	}
+	return out, nil
+}
+
+// IngestErrorCounts returns the number of files which could not be ingested,
+// keyed by source.
+func IngestErrorCounts(ctx context.Context, db *sql.DB) (map[int]int, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+		SELECT source_id, COUNT(*) FROM ingest_errors GROUP BY source_id;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := map[int]int{}
+	for res.Next() {
+		var uid, count int
+		if err := res.Scan(&uid, &count); err != nil {
+			return nil, err
+		}
+		out[uid] = count
+	}
+	return out, nil
+}
+
+// UpdateIngestErrors clears the errors recorded against the given paths of a
+// source, or all its paths if all is set, then records errs.
+func UpdateIngestErrors(ctx context.Context, sourceUID int, all bool, paths []string, errs []*IngestError, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if all {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM ingest_errors WHERE source_id = ?;`, sourceUID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		for _, p := range paths {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM ingest_errors WHERE source_id = ? AND path = ?;`, sourceUID, p); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	for _, e := range errs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO ingest_errors (source_id, path, message, commit_hash) VALUES (?, ?, ?, ?);`, sourceUID, e.Path, e.Message, e.Commit)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
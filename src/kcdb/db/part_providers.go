@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PartProviderTable records which sources provide each part ingested from a
+// git submodule. Several sources may embed the same submodule, in which case
+// they write the same part URLs, so a part is only removed once no source
+// provides it.
+type PartProviderTable struct{}
+
+// Setup is called on initialization to create necessary structures in the database.
+func (t *PartProviderTable) Setup(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT url FROM part_providers LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+  	CREATE TABLE IF NOT EXISTS part_providers (
+  		rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+      url VARCHAR(1024) NOT NULL,
+  	  source_id INT NOT NULL
+  	);
+    CREATE UNIQUE INDEX IF NOT EXISTS part_providers_ref ON part_providers(url, source_id);
+    CREATE INDEX IF NOT EXISTS part_providers_source ON part_providers(source_id);
+	`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Parts already ingested from submodules have no providers recorded
+	// until they are ingested again.
+	if err := forceReingest(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddPartProvider records that the source provides the part at url.
+func AddPartProvider(ctx context.Context, url string, sourceUID int, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	_, err := db.ExecContext(ctx, `
+    INSERT OR IGNORE INTO part_providers (url, source_id) VALUES (?, ?);
+  `, url, sourceUID)
+	return err
+}
+
+// PartProviderURLs returns the URLs of the parts which the source provides.
+func PartProviderURLs(ctx context.Context, sourceUID int, db *sql.DB) ([]string, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT url FROM part_providers WHERE source_id = ?;
+  `, sourceUID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var out []string
+	for res.Next() {
+		var url string
+		if err := res.Scan(&url); err != nil {
+			return nil, err
+		}
+		out = append(out, url)
+	}
+	return out, nil
+}
+
+// RemovePartProviders records that the source no longer provides the parts
+// at the given URLs.
+func RemovePartProviders(ctx context.Context, sourceUID int, urls []string, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, url := range urls {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM part_providers WHERE url = ? AND source_id = ?;`, url, sourceUID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PartProvider returns the UID of a source which provides the part at url,
+// or 0 if no source does.
+func PartProvider(ctx context.Context, url string, db *sql.DB) (int, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	var uid int
+	err := db.QueryRowContext(ctx, `
+    SELECT source_id FROM part_providers WHERE url = ? ORDER BY rowid LIMIT 1;
+  `, url).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return uid, err
+}
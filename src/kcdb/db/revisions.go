@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+// RevisionTable contains the historical content of footprints and symbols.
+type RevisionTable struct{}
+
+// Setup is called on initialization to create necessary structures in the database.
+func (t *RevisionTable) Setup(ctx context.Context, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"footprint_revisions", "symbol_revisions"} {
+		_, err = tx.Exec(`
+  	CREATE TABLE IF NOT EXISTS ` + table + ` (
+  		rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+  	  part_id INT NOT NULL,
+  	  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			content_hash VARCHAR(64) NOT NULL,
+			commit_hash VARCHAR(64) NOT NULL DEFAULT '',
+			commit_date TIMESTAMP NOT NULL DEFAULT 0,
+      data BLOB NOT NULL
+  	);
+    CREATE INDEX IF NOT EXISTS ` + table + `_part ON ` + table + `(part_id);
+		`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Revision describes a version of the content of a footprint or symbol.
+type Revision struct {
+	UID         int       `json:"uid"`
+	PartID      int       `json:"part_uid"`
+	CreatedAt   time.Time `json:"created_at"`
+	ContentHash string    `json:"content_hash"`
+	Commit      string    `json:"commit"`
+	CommitDate  time.Time `json:"commit_date"`
+	Data        []byte    `json:"data,omitempty"`
+}
+
+func contentHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// recordRevision adds a revision for a part if its content differs from the
+// latest revision. The old content of parts ingested before revisions were
+// tracked is recorded first, without commit information.
+func recordRevision(ctx context.Context, tx *sql.Tx, table, partTable string, partID int, data []byte, commit string, commitDate time.Time) error {
+	hash := contentHash(data)
+
+	var latest string
+	err := tx.QueryRowContext(ctx, `SELECT content_hash FROM `+table+` WHERE part_id = ? ORDER BY rowid DESC LIMIT 1;`, partID).Scan(&latest)
+	switch {
+	case err == sql.ErrNoRows:
+		var old []byte
+		err := tx.QueryRowContext(ctx, `SELECT data FROM `+partTable+` WHERE rowid = ?;`, partID).Scan(&old)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && contentHash(old) != hash {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO `+table+` (part_id, content_hash, data) VALUES (?, ?, ?);`, partID, contentHash(old), old); err != nil {
+				return err
+			}
+		}
+	case err != nil:
+		return err
+	case latest == hash:
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO `+table+` (part_id, content_hash, commit_hash, commit_date, data) VALUES (?, ?, ?, ?, ?);`, partID, hash, commit, commitDate, data)
+	return err
+}
+
+func revisions(ctx context.Context, table string, partID int, db *sql.DB) ([]*Revision, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT rowid, part_id, created_at, content_hash, commit_hash, commit_date FROM `+table+` WHERE part_id = ? ORDER BY rowid DESC;
+  `, partID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var out []*Revision
+	for res.Next() {
+		var r Revision
+		if err := res.Scan(&r.UID, &r.PartID, &r.CreatedAt, &r.ContentHash, &r.Commit, &r.CommitDate); err != nil {
+			return nil, err
+		}
+		out = append(out, &r)
+	}
+	return out, nil
+}
+
+func revision(ctx context.Context, table string, partID, uid int, db *sql.DB) (*Revision, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT rowid, part_id, created_at, content_hash, commit_hash, commit_date, data FROM `+table+` WHERE part_id = ? AND rowid = ?;
+  `, partID, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	if !res.Next() {
+		return nil, os.ErrNotExist
+	}
+	var r Revision
+	return &r, res.Scan(&r.UID, &r.PartID, &r.CreatedAt, &r.ContentHash, &r.Commit, &r.CommitDate, &r.Data)
+}
+
+// FootprintRevisions returns the revisions of a footprint, newest first. The
+// data of each revision is not populated.
+func FootprintRevisions(ctx context.Context, footprintUID int, db *sql.DB) ([]*Revision, error) {
+	return revisions(ctx, "footprint_revisions", footprintUID, db)
+}
+
+// FootprintRevision returns a specific revision of a footprint.
+func FootprintRevision(ctx context.Context, footprintUID, uid int, db *sql.DB) (*Revision, error) {
+	return revision(ctx, "footprint_revisions", footprintUID, uid, db)
+}
+
+// SymbolRevisions returns the revisions of a symbol, newest first. The data of
+// each revision is not populated.
+func SymbolRevisions(ctx context.Context, symbolUID int, db *sql.DB) ([]*Revision, error) {
+	return revisions(ctx, "symbol_revisions", symbolUID, db)
+}
+
+// SymbolRevision returns a specific revision of a symbol.
+func SymbolRevision(ctx context.Context, symbolUID, uid int, db *sql.DB) (*Revision, error) {
+	return revision(ctx, "symbol_revisions", symbolUID, uid, db)
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SourceCandidateTable records repositories which are referenced by the
+// library tables of a source, but are not sources themselves.
+type SourceCandidateTable struct{}
+
+// Setup is called on initialization to create necessary structures in the database.
+func (t *SourceCandidateTable) Setup(ctx context.Context, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+  	CREATE TABLE IF NOT EXISTS source_candidates (
+  		rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+      url VARCHAR(1024) NOT NULL,
+  	  source_id INT NOT NULL,
+      path VARCHAR(1024) NOT NULL,
+			ref_count INT NOT NULL DEFAULT 0,
+  	  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+  	);
+    CREATE UNIQUE INDEX IF NOT EXISTS source_candidates_ref ON source_candidates(source_id, path, url);
+    CREATE INDEX IF NOT EXISTS source_candidates_url ON source_candidates(url);
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SourceCandidate describes a repository which could be added as a source.
+type SourceCandidate struct {
+	UID int    `json:"uid"`
+	URL string `json:"url"`
+	// SourceID and Path identify the library table which referenced the
+	// repository.
+	SourceID int    `json:"source_uid,omitempty"`
+	Path     string `json:"path,omitempty"`
+	// Count is the number of libraries which reference the repository.
+	Count int `json:"count"`
+
+	// Not stored in DB
+	Referrers []int `json:"referrers,omitempty"`
+}
+
+// GetSourceCandidates returns the repositories referenced by sources which are
+// not yet sources themselves, most referenced first. The references from each
+// source are combined, and the UID of each is the UID of one of its references.
+func GetSourceCandidates(ctx context.Context, db *sql.DB) ([]*SourceCandidate, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+		SELECT MIN(rowid), url, SUM(ref_count), GROUP_CONCAT(DISTINCT source_id) FROM source_candidates
+		WHERE url NOT IN (SELECT url FROM sources)
+		GROUP BY url ORDER BY SUM(ref_count) DESC, url;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var out []*SourceCandidate
+	for res.Next() {
+		var (
+			c         SourceCandidate
+			referrers string
+		)
+		if err := res.Scan(&c.UID, &c.URL, &c.Count, &referrers); err != nil {
+			return nil, err
+		}
+		for _, s := range strings.Split(referrers, ",") {
+			if uid, err := strconv.Atoi(s); err == nil {
+				c.Referrers = append(c.Referrers, uid)
+			}
+		}
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+// UpdateSourceCandidates clears the candidates recorded from the given paths
+// of a source, or all its paths if all is set, then records cands.
+func UpdateSourceCandidates(ctx context.Context, sourceUID int, all bool, paths []string, cands []*SourceCandidate, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if all {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM source_candidates WHERE source_id = ?;`, sourceUID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		for _, p := range paths {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM source_candidates WHERE source_id = ? AND path = ?;`, sourceUID, p); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	for _, c := range cands {
+		_, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO source_candidates (url, source_id, path, ref_count) VALUES (?, ?, ?, ?);`, c.URL, sourceUID, c.Path, c.Count)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PromoteSourceCandidate adds the repository referenced by the candidate with
+// the given UID as a git source, returning the UID of the new source.
+func PromoteSourceCandidate(ctx context.Context, uid, rank int, tag string, db *sql.DB) (int, error) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var url string
+	if err := tx.QueryRowContext(ctx, `SELECT url FROM source_candidates WHERE rowid = ?;`, uid).Scan(&url); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return 0, os.ErrNotExist
+		}
+		return 0, err
+	}
+	e, err := tx.ExecContext(ctx, `
+    INSERT INTO
+      sources (kind, url, ranking_priority, tag)
+      VALUES (?, ?, ?, ?);`, SourceKindGit, url, rank, tag)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	sourceUID, err := e.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM source_candidates WHERE url = ?;`, url); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return int(sourceUID), tx.Commit()
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SourceFileTable records the state of files in sources which are scanned
+// from the filesystem, so unchanged files can be skipped.
+type SourceFileTable struct{}
+
+// Setup is called on initialization to create necessary structures in the database.
+func (t *SourceFileTable) Setup(ctx context.Context, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+  	CREATE TABLE IF NOT EXISTS source_files (
+  		rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+  	  source_id INT NOT NULL,
+      path VARCHAR(1024) NOT NULL,
+			size INT NOT NULL,
+			mod_time INT NOT NULL
+  	);
+    CREATE UNIQUE INDEX IF NOT EXISTS source_files_path ON source_files(source_id, path);
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SourceFile describes the state of a file when it was last ingested.
+type SourceFile struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// GetSourceFiles returns the recorded state of each file in a source, keyed by path.
+func GetSourceFiles(ctx context.Context, sourceUID int, db *sql.DB) (map[string]SourceFile, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+		SELECT path, size, mod_time FROM source_files WHERE source_id = ?;
+	`, sourceUID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := map[string]SourceFile{}
+	for res.Next() {
+		var path string
+		var size, modTime int64
+		if err := res.Scan(&path, &size, &modTime); err != nil {
+			return nil, err
+		}
+		out[path] = SourceFile{Size: size, ModTime: time.Unix(0, modTime)}
+	}
+	return out, nil
+}
+
+// SetSourceFiles replaces the recorded state of files in a source.
+func SetSourceFiles(ctx context.Context, sourceUID int, files map[string]SourceFile, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM source_files WHERE source_id = ?;`, sourceUID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for path, f := range files {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO source_files (source_id, path, size, mod_time) VALUES (?, ?, ?, ?);`, sourceUID, path, f.Size, f.ModTime.UnixNano())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
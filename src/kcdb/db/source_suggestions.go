@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"time"
+)
+
+// Suggestion statuses.
+const (
+	SuggestionPending  = "pending"
+	SuggestionApproved = "approved"
+	SuggestionRejected = "rejected"
+)
+
+// SourceSuggestionTable contains repositories which users have suggested
+// should be added as sources.
+type SourceSuggestionTable struct{}
+
+// Setup is called on initialization to create necessary structures in the database.
+func (t *SourceSuggestionTable) Setup(ctx context.Context, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+  	CREATE TABLE IF NOT EXISTS source_suggestions (
+  		rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+      url VARCHAR(1024) NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			remote_addr VARCHAR(64) NOT NULL DEFAULT '',
+  	  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			status VARCHAR(16) NOT NULL DEFAULT 'pending',
+			reason TEXT NOT NULL DEFAULT '',
+			source_id INT NOT NULL DEFAULT 0
+  	);
+    CREATE INDEX IF NOT EXISTS source_suggestions_url ON source_suggestions(url);
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SourceSuggestion describes a repository which was suggested as a source.
+type SourceSuggestion struct {
+	UID        int       `json:"uid"`
+	URL        string    `json:"url"`
+	Note       string    `json:"note"`
+	RemoteAddr string    `json:"remote_addr"`
+	CreatedAt  time.Time `json:"created_at"`
+	Status     string    `json:"status"`
+	// Reason explains why a suggestion was rejected.
+	Reason string `json:"reason,omitempty"`
+	// SourceID is the source which was created when the suggestion was approved.
+	SourceID int `json:"source_uid,omitempty"`
+}
+
+// AddSourceSuggestion records a pending suggestion, returning its UID.
+func AddSourceSuggestion(ctx context.Context, s *SourceSuggestion, db *sql.DB) (int, error) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	e, err := tx.ExecContext(ctx, `
+    INSERT INTO
+      source_suggestions (url, note, remote_addr)
+      VALUES (?, ?, ?);`, s.URL, s.Note, s.RemoteAddr)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := e.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// SuggestionPendingForURL returns true if the repository at url has already
+// been suggested, and that suggestion is yet to be reviewed.
+func SuggestionPendingForURL(ctx context.Context, url string, db *sql.DB) (bool, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT rowid FROM source_suggestions WHERE url = ? AND status = ? LIMIT 1;
+  `, url, SuggestionPending)
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+	return res.Next(), nil
+}
+
+// GetSourceSuggestions returns suggestions with the given status, or every
+// suggestion if status is empty, oldest first.
+func GetSourceSuggestions(ctx context.Context, status string, db *sql.DB) ([]*SourceSuggestion, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+		SELECT rowid, url, note, remote_addr, created_at, status, reason, source_id FROM source_suggestions
+		WHERE ? = '' OR status = ? ORDER BY rowid;
+	`, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var out []*SourceSuggestion
+	for res.Next() {
+		var s SourceSuggestion
+		if err := res.Scan(&s.UID, &s.URL, &s.Note, &s.RemoteAddr, &s.CreatedAt, &s.Status, &s.Reason, &s.SourceID); err != nil {
+			return nil, err
+		}
+		out = append(out, &s)
+	}
+	return out, nil
+}
+
+// pendingSuggestionURL returns the URL of the pending suggestion with the given
+// UID, or os.ErrNotExist if there is no such suggestion.
+func pendingSuggestionURL(ctx context.Context, tx *sql.Tx, uid int) (string, error) {
+	var url string
+	err := tx.QueryRowContext(ctx, `
+		SELECT url FROM source_suggestions WHERE rowid = ? AND status = ?;`, uid, SuggestionPending).Scan(&url)
+	if err == sql.ErrNoRows {
+		return "", os.ErrNotExist
+	}
+	return url, err
+}
+
+// ApproveSourceSuggestion adds the suggested repository as a git source with
+// the given rank & tag, returning the UID of the new source.
+func ApproveSourceSuggestion(ctx context.Context, uid, rank int, tag string, db *sql.DB) (int, error) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	url, err := pendingSuggestionURL(ctx, tx, uid)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	e, err := tx.ExecContext(ctx, `
+    INSERT INTO
+      sources (kind, url, ranking_priority, tag)
+      VALUES (?, ?, ?, ?);`, SourceKindGit, url, rank, tag)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	sourceUID, err := e.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE source_suggestions SET status = ?, source_id = ? WHERE rowid = ?;`, SuggestionApproved, sourceUID, uid)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return int(sourceUID), tx.Commit()
+}
+
+// RejectSourceSuggestion marks a pending suggestion as rejected.
+func RejectSourceSuggestion(ctx context.Context, uid int, reason string, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := pendingSuggestionURL(ctx, tx, uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE source_suggestions SET status = ?, reason = ? WHERE rowid = ?;`, SuggestionRejected, reason, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -3,13 +3,20 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path"
+	"strings"
 	"time"
 )
 
 // source kinds.
 const (
-	SourceKindGit = "git"
+	SourceKindGit     = "git"
+	SourceKindDir     = "dir"
+	SourceKindArchive = "archive"
 )
 
 // SourceTable lists repositories to pull kicad files from.
@@ -30,7 +37,14 @@ func (t *SourceTable) Setup(ctx context.Context, db *sql.DB) error {
       url VARCHAR(512) NOT NULL,
 			ranking_priority INT NOT NULL DEFAULT 0,
 			tag VARCHAR(128) NOT NULL DEFAULT '',
-			metadata VARCHAR(2048) NOT NULL DEFAULT '{}'
+			metadata VARCHAR(2048) NOT NULL DEFAULT '{}',
+			last_commit VARCHAR(64) NOT NULL DEFAULT '',
+			webhook_secret VARCHAR(256) NOT NULL DEFAULT '',
+			failure_count INT NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL DEFAULT 0,
+			disabled BOOLEAN NOT NULL DEFAULT 0,
+			credentials TEXT NOT NULL DEFAULT ''
   	);
     CREATE UNIQUE INDEX IF NOT EXISTS sources_url ON sources(url);
 	`)
@@ -40,7 +54,19 @@ func (t *SourceTable) Setup(ctx context.Context, db *sql.DB) error {
 	if err = tx.Commit(); err != nil {
 		return err
 	}
-	return t.migratev1(ctx, db)
+	if err := t.migratev1(ctx, db); err != nil {
+		return err
+	}
+	if err := t.migratev2(ctx, db); err != nil {
+		return err
+	}
+	if err := t.migratev3(ctx, db); err != nil {
+		return err
+	}
+	if err := t.migratev4(ctx, db); err != nil {
+		return err
+	}
+	return t.migratev5(ctx, db)
 }
 
 func (t *SourceTable) migratev1(ctx context.Context, db *sql.DB) error {
@@ -71,6 +97,113 @@ func (t *SourceTable) migratev1(ctx context.Context, db *sql.DB) error {
 	return tx.Commit()
 }
 
+func (t *SourceTable) migratev2(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT last_commit FROM sources LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN last_commit VARCHAR(64) NOT NULL DEFAULT '';`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *SourceTable) migratev3(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT webhook_secret FROM sources LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN webhook_secret VARCHAR(256) NOT NULL DEFAULT '';`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *SourceTable) migratev4(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT failure_count FROM sources LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN failure_count INT NOT NULL DEFAULT 0;`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN last_error TEXT NOT NULL DEFAULT '';`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN next_attempt_at TIMESTAMP NOT NULL DEFAULT 0;`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT 0;`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *SourceTable) migratev5(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT credentials FROM sources LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE sources
+		ADD COLUMN credentials TEXT NOT NULL DEFAULT '';`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// forceReingest clears the state used to skip unchanged sources and files, so
+// every source is ingested in full on its next attempt.
+func forceReingest(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE sources SET last_commit = '';`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM source_files;`)
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return nil
+	}
+	return err
+}
+
 // Source records a single source from which kc files are ingested.
 type Source struct {
 	UID       int       `json:"uid"`
@@ -81,6 +214,83 @@ type Source struct {
 	Rank      int       `json:"rank"`
 	Tag       string    `json:"tag"`
 	Metadata  string    `json:"metadata"`
+
+	// LastCommit is the hash of the commit most recently ingested.
+	LastCommit string `json:"last_commit"`
+	// WebhookSecret authenticates push notifications for the source.
+	WebhookSecret string `json:"-"`
+
+	// FailureCount is the number of consecutive failed ingests, LastError
+	// describes the most recent failure, and the source is not ingested on
+	// schedule before NextAttemptAt. Disabled sources are never ingested on
+	// schedule.
+	FailureCount  int       `json:"failure_count"`
+	LastError     string    `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	Disabled      bool      `json:"disabled"`
+
+	// Credentials are the encrypted credentials used to access the source.
+	// Sources with credentials are private, and their parts are hidden from
+	// the public UI.
+	Credentials string `json:"-"`
+	Private     bool   `json:"private"`
+
+	// Not stored in DB
+	ErrorCount int `json:"error_count"`
+}
+
+// SourceMetadata describes per-source ingest settings. It is stored as JSON
+// in the metadata column of a source.
+type SourceMetadata struct {
+	// Ref is the branch or tag to ingest. The default branch is used if empty.
+	Ref string `json:"ref,omitempty"`
+	// Subdir is the directory within the source to ingest from.
+	Subdir string `json:"subdir,omitempty"`
+	// Include and Exclude are glob patterns matched against paths relative to
+	// Subdir. If Include is non-empty, only matching paths are ingested.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Validate returns an error if the metadata is malformed.
+func (m *SourceMetadata) Validate() error {
+	if path.IsAbs(m.Subdir) {
+		return errors.New("subdir must be a relative path")
+	}
+	for _, s := range strings.Split(m.Subdir, "/") {
+		if s == ".." {
+			return errors.New("subdir cannot reference a parent directory")
+		}
+	}
+	for _, p := range append(append([]string{}, m.Include...), m.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// ParseMetadata decodes the metadata of the source.
+func (s *Source) ParseMetadata() (*SourceMetadata, error) {
+	var m SourceMetadata
+	if s.Metadata == "" {
+		return &m, nil
+	}
+	if err := json.Unmarshal([]byte(s.Metadata), &m); err != nil {
+		return nil, err
+	}
+	return &m, m.Validate()
+}
+
+const sourceFields = "rowid, kind, created_at, updated_at, url, ranking_priority, tag, metadata, last_commit, webhook_secret, failure_count, last_error, next_attempt_at, disabled, credentials"
+
+func scanSource(res *sql.Rows) (*Source, error) {
+	var o Source
+	if err := res.Scan(&o.UID, &o.Kind, &o.CreatedAt, &o.UpdatedAt, &o.URL, &o.Rank, &o.Tag, &o.Metadata, &o.LastCommit, &o.WebhookSecret, &o.FailureCount, &o.LastError, &o.NextAttemptAt, &o.Disabled, &o.Credentials); err != nil {
+		return nil, err
+	}
+	o.Private = o.Credentials != ""
+	return &o, nil
 }
 
 // AddSource commits a new source record.
@@ -102,7 +312,9 @@ func AddSource(ctx context.Context, src *Source, db *sql.DB) error {
 	return tx.Commit()
 }
 
-// CreateSource creates a new source, keeping the UID provided.
+// CreateSource creates a new source, keeping the UID provided. The webhook
+// secret and credentials of src are not stored, as they are not included when
+// sources are dumped, so must be set separately.
 func CreateSource(ctx context.Context, src *Source, db *sql.DB) error {
 	dbLock.Lock()
 	defer dbLock.Unlock()
@@ -123,13 +335,15 @@ func CreateSource(ctx context.Context, src *Source, db *sql.DB) error {
 }
 
 // SourcesLastUpdated returns sources in order of least-recently updated.
+// Disabled sources, and sources which are backing off after a failure, are
+// omitted.
 func SourcesLastUpdated(ctx context.Context, limit int, db *sql.DB) ([]*Source, error) {
 	dbLock.RLock()
 	defer dbLock.RUnlock()
 
 	res, err := db.QueryContext(ctx, `
-		SELECT rowid, kind, created_at, updated_at, url, ranking_priority, tag, metadata FROM sources ORDER BY updated_at ASC LIMIT ?;
-	`, limit)
+		SELECT `+sourceFields+` FROM sources WHERE disabled = 0 AND next_attempt_at <= ? ORDER BY updated_at ASC LIMIT ?;
+	`, time.Now().UTC(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -137,17 +351,18 @@ func SourcesLastUpdated(ctx context.Context, limit int, db *sql.DB) ([]*Source,
 
 	var output []*Source
 	for res.Next() {
-		var o Source
-		if err := res.Scan(&o.UID, &o.Kind, &o.CreatedAt, &o.UpdatedAt, &o.URL, &o.Rank, &o.Tag, &o.Metadata); err != nil {
+		o, err := scanSource(res)
+		if err != nil {
 			return nil, err
 		}
-		output = append(output, &o)
+		output = append(output, o)
 	}
 
 	return output, nil
 }
 
-// SetSourceUpdated updates the updated_at value of the given source to the current time.
+// SetSourceUpdated updates the updated_at value of the given source to the current time,
+// and clears any record of failed ingests.
 func SetSourceUpdated(ctx context.Context, uid int, db *sql.DB) error {
 	dbLock.Lock()
 	defer dbLock.Unlock()
@@ -158,13 +373,141 @@ func SetSourceUpdated(ctx context.Context, uid int, db *sql.DB) error {
 	}
 
 	_, err = tx.ExecContext(ctx, `
-		UPDATE sources SET updated_at=CURRENT_TIMESTAMP WHERE rowid = ?;`, uid)
+		UPDATE sources SET updated_at=CURRENT_TIMESTAMP, failure_count=0, last_error='', next_attempt_at=0 WHERE rowid = ?;`, uid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSourceFailed records a failed ingest of the given source. The source is
+// not ingested on schedule again until nextAttempt, and is disabled if disable
+// is set.
+func SetSourceFailed(ctx context.Context, uid int, msg string, nextAttempt time.Time, disable bool, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sources SET failure_count=failure_count+1, last_error=?, next_attempt_at=?, disabled=? WHERE rowid = ?;`, msg, nextAttempt.UTC(), disable, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSourceDisabled disables or re-enables scheduled ingests of a source.
+// Re-enabling a source clears its record of failed ingests.
+func SetSourceDisabled(ctx context.Context, uid int, disabled bool, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	q := `UPDATE sources SET disabled=1 WHERE rowid = ?;`
+	if !disabled {
+		q = `UPDATE sources SET disabled=0, failure_count=0, last_error='', next_attempt_at=0 WHERE rowid = ?;`
+	}
+	if _, err = tx.ExecContext(ctx, q, uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSourceCommit records the hash of the most recently ingested commit for the given source.
+func SetSourceCommit(ctx context.Context, uid int, commit string, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sources SET last_commit=? WHERE rowid = ?;`, commit, uid)
 	if err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
+// SetSourceMetadata sets the metadata for a source. The last ingested commit and
+// recorded file states are cleared, so the next ingest considers every file
+// under the new settings.
+func SetSourceMetadata(ctx context.Context, uid int, metadata string, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sources SET metadata=?, last_commit='' WHERE rowid = ?;`, metadata, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM source_files WHERE source_id = ?;`, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSourceWebhookSecret sets the secret used to authenticate push
+// notifications for a source.
+func SetSourceWebhookSecret(ctx context.Context, uid int, secret string, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sources SET webhook_secret=? WHERE rowid = ?;`, secret, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSourceCredentials sets the encrypted credentials used to access a source.
+// An empty value removes any credentials.
+func SetSourceCredentials(ctx context.Context, uid int, sealed string, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sources SET credentials=? WHERE rowid = ?;`, sealed, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 // SetSourceAdmin sets the tag and rank for a source.
 func SetSourceAdmin(ctx context.Context, uid, rank int, tag string, db *sql.DB) error {
 	dbLock.Lock()
@@ -189,7 +532,7 @@ func GetSources(ctx context.Context, db *sql.DB) ([]*Source, error) {
 	defer dbLock.RUnlock()
 
 	res, err := db.QueryContext(ctx, `
-		SELECT rowid, kind, created_at, updated_at, url, ranking_priority, tag, metadata FROM sources;
+		SELECT `+sourceFields+` FROM sources;
 	`)
 	if err != nil {
 		return nil, err
@@ -198,11 +541,11 @@ func GetSources(ctx context.Context, db *sql.DB) ([]*Source, error) {
 
 	var output []*Source
 	for res.Next() {
-		var o Source
-		if err := res.Scan(&o.UID, &o.Kind, &o.CreatedAt, &o.UpdatedAt, &o.URL, &o.Rank, &o.Tag, &o.Metadata); err != nil {
+		o, err := scanSource(res)
+		if err != nil {
 			return nil, err
 		}
-		output = append(output, &o)
+		output = append(output, o)
 	}
 
 	return output, nil
@@ -214,7 +557,7 @@ func GetSource(ctx context.Context, uid int, db *sql.DB) (*Source, error) {
 	defer dbLock.RUnlock()
 
 	res, err := db.QueryContext(ctx, `
-		SELECT rowid, kind, created_at, updated_at, url, ranking_priority, tag, metadata FROM sources WHERE rowid = ?;
+		SELECT `+sourceFields+` FROM sources WHERE rowid = ?;
 	`, uid)
 	if err != nil {
 		return nil, err
@@ -225,6 +568,24 @@ func GetSource(ctx context.Context, uid int, db *sql.DB) (*Source, error) {
 		// fmt.Printf("Failed to find source with UID %v\n", uid)
 		return nil, os.ErrNotExist
 	}
-	var o Source
-	return &o, res.Scan(&o.UID, &o.Kind, &o.CreatedAt, &o.UpdatedAt, &o.URL, &o.Rank, &o.Tag, &o.Metadata)
+	return scanSource(res)
+}
+
+// GetSourceByURL returns the source with the given URL.
+func GetSourceByURL(ctx context.Context, url string, db *sql.DB) (*Source, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+		SELECT `+sourceFields+` FROM sources WHERE url = ?;
+	`, url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	if !res.Next() {
+		return nil, os.ErrNotExist
+	}
+	return scanSource(res)
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -27,7 +28,14 @@ func (t *SymbolTable) Setup(ctx context.Context, db *sql.DB) error {
 			condensed_fields VARCHAR(32768) NOT NULL,
       data BLOB NOT NULL,
 			pin_count INT NOT NULL DEFAULT 0,
-			condensed_pins VARCHAR(32768) NOT NULL DEFAULT ''
+			condensed_pins VARCHAR(32768) NOT NULL DEFAULT '',
+			content_hash VARCHAR(64) NOT NULL DEFAULT '',
+			description VARCHAR(1024) NOT NULL DEFAULT '',
+			keywords VARCHAR(1024) NOT NULL DEFAULT '',
+			datasheet VARCHAR(1024) NOT NULL DEFAULT '',
+			units INT NOT NULL DEFAULT 1,
+			power BOOLEAN NOT NULL DEFAULT 0,
+			de_morgan BOOLEAN NOT NULL DEFAULT 0
   	);
 		CREATE UNIQUE INDEX IF NOT EXISTS symbols_url ON symbols(url);
 	`)
@@ -37,7 +45,21 @@ func (t *SymbolTable) Setup(ctx context.Context, db *sql.DB) error {
 	if err = tx.Commit(); err != nil {
 		return err
 	}
-	return t.migratev1(ctx, db)
+	if err := t.migratev1(ctx, db); err != nil {
+		return err
+	}
+	if err := t.migratev2(ctx, db); err != nil {
+		return err
+	}
+	if err := t.migratev3(ctx, db); err != nil {
+		return err
+	}
+	if err := t.migratev4(ctx, db); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+    CREATE INDEX IF NOT EXISTS symbols_content_hash ON symbols(content_hash);`)
+	return err
 }
 
 func (t *SymbolTable) migratev1(ctx context.Context, db *sql.DB) error {
@@ -63,6 +85,86 @@ func (t *SymbolTable) migratev1(ctx context.Context, db *sql.DB) error {
 	return tx.Commit()
 }
 
+func (t *SymbolTable) migratev2(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT content_hash FROM symbols LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE symbols
+		ADD COLUMN content_hash VARCHAR(64) NOT NULL DEFAULT '';`)
+	if err != nil {
+		return err
+	}
+	// Existing symbols have no content hash until they are ingested again.
+	if err := forceReingest(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *SymbolTable) migratev3(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT description FROM symbols LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, col := range []string{"description", "keywords", "datasheet"} {
+		_, err = tx.Exec(`ALTER TABLE symbols
+			ADD COLUMN ` + col + ` VARCHAR(1024) NOT NULL DEFAULT '';`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// Existing symbols are undocumented until they are ingested again.
+	if err := forceReingest(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *SymbolTable) migratev4(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT units FROM symbols LIMIT 1;")
+	if err == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, col := range []string{
+		"units INT NOT NULL DEFAULT 1",
+		"power BOOLEAN NOT NULL DEFAULT 0",
+		"de_morgan BOOLEAN NOT NULL DEFAULT 0",
+	} {
+		_, err = tx.Exec(`ALTER TABLE symbols
+			ADD COLUMN ` + col + `;`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// Existing symbols are not known to be power symbols until they are
+	// ingested again.
+	if err := forceReingest(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 // Symbol contains information about a symbol.
 type Symbol struct {
 	UID       int       `json:"uid"`
@@ -76,8 +178,31 @@ type Symbol struct {
 	PinData   string `json:"pin_data"`
 
 	PinCount int `json:"pin_count"`
+
+	// Description, Keywords and Datasheet document the symbol.
+	Description string `json:"description,omitempty"`
+	Keywords    string `json:"keywords,omitempty"`
+	Datasheet   string `json:"datasheet,omitempty"`
+
+	// Units is the number of units in the symbol. Power is set for power
+	// symbols, and DeMorgan for symbols with an alternate body style.
+	Units    int  `json:"units"`
+	Power    bool `json:"power,omitempty"`
+	DeMorgan bool `json:"de_morgan,omitempty"`
+
+	// ContentHash identifies the definition of the symbol, ignoring
+	// formatting, so copies of the symbol in other sources can be found.
+	ContentHash string `json:"content_hash,omitempty"`
+
 	// Not stored in DB
-	Rank int `json:"rank,omitempty"`
+	Rank    int  `json:"rank,omitempty"`
+	Private bool `json:"private,omitempty"`
+	// AlsoFoundIn is the number of other sources with an identical symbol.
+	AlsoFoundIn int `json:"also_found_in,omitempty"`
+	// Commit and CommitDate describe where the data was ingested from. They
+	// are recorded against a new revision when the data changes.
+	Commit     string    `json:"-"`
+	CommitDate time.Time `json:"-"`
 }
 
 // SymbolExists identifies if a symbol is stored with that URL.
@@ -112,8 +237,13 @@ func UpdateSymbol(ctx context.Context, sym *Symbol, db *sql.DB) error {
 		return err
 	}
 
+	if err := recordRevision(ctx, tx, "symbol_revisions", "symbols", sym.UID, sym.Data, sym.Commit, sym.CommitDate); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	_, err = tx.ExecContext(ctx, `
-    UPDATE symbols SET data=?, name=?, condensed_fields=?, pin_count=?, condensed_pins=?, updated_at=CURRENT_TIMESTAMP WHERE rowid = ?;`, sym.Data, sym.Name, sym.FieldData, sym.PinCount, sym.PinData, sym.UID)
+    UPDATE symbols SET data=?, name=?, condensed_fields=?, pin_count=?, condensed_pins=?, content_hash=?, description=?, keywords=?, datasheet=?, units=?, power=?, de_morgan=?, updated_at=CURRENT_TIMESTAMP WHERE rowid = ?;`, sym.Data, sym.Name, sym.FieldData, sym.PinCount, sym.PinData, sym.ContentHash, sym.Description, sym.Keywords, sym.Datasheet, sym.Units, sym.Power, sym.DeMorgan, sym.UID)
 	if err != nil {
 		return err
 	}
@@ -131,29 +261,97 @@ func CreateSymbol(ctx context.Context, sym *Symbol, db *sql.DB) (int, error) {
 	}
 	e, err := tx.ExecContext(ctx, `
     INSERT INTO
-      symbols (source_id, url, data, name, condensed_fields, pin_count, condensed_pins)
-      VALUES (?, ?, ?, ?, ?, ?, ?);`, sym.SourceID, sym.URL, sym.Data, sym.Name, sym.FieldData, sym.PinCount, sym.PinData)
+      symbols (source_id, url, data, name, condensed_fields, pin_count, condensed_pins, content_hash, description, keywords, datasheet, units, power, de_morgan)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, sym.SourceID, sym.URL, sym.Data, sym.Name, sym.FieldData, sym.PinCount, sym.PinData, sym.ContentHash, sym.Description, sym.Keywords, sym.Datasheet, sym.Units, sym.Power, sym.DeMorgan)
 	if err != nil {
 		return 0, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return 0, err
-	}
 	id, err := e.LastInsertId()
 	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := recordRevision(ctx, tx, "symbol_revisions", "symbols", int(id), sym.Data, sym.Commit, sym.CommitDate); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return 0, err
 	}
 	return int(id), nil
 }
 
+// SymbolHashSources returns the number of distinct public sources which
+// contain a symbol with each of the given content hashes.
+func SymbolHashSources(ctx context.Context, hashes []string, db *sql.DB) (map[string]int, error) {
+	return hashSources(ctx, "symbols", hashes, db)
+}
+
+// SymbolURLsBySource returns the URL and UID of every symbol from the given source.
+func SymbolURLsBySource(ctx context.Context, sourceUID int, db *sql.DB) (map[string]int, error) {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	res, err := db.QueryContext(ctx, `
+    SELECT rowid, url FROM symbols WHERE source_id = ?;
+  `, sourceUID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := map[string]int{}
+	for res.Next() {
+		var uid int
+		var url string
+		if err := res.Scan(&uid, &url); err != nil {
+			return nil, err
+		}
+		out[url] = uid
+	}
+	return out, nil
+}
+
+// SetSymbolSource attributes the symbol with the given UID to another source.
+func SetSymbolSource(ctx context.Context, uid, sourceUID int, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	_, err := db.ExecContext(ctx, `UPDATE symbols SET source_id = ? WHERE rowid = ?;`, sourceUID, uid)
+	return err
+}
+
+// DeleteSymbols removes the symbols with the given UIDs, along with their revisions.
+func DeleteSymbols(ctx context.Context, uids []int, db *sql.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM symbols WHERE rowid = ?;`, uid); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM symbol_revisions WHERE part_id = ?;`, uid); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // SymbolByURL returns the specified symbol
 func SymbolByURL(ctx context.Context, url string, db *sql.DB) (*Symbol, error) {
 	dbLock.RLock()
 	defer dbLock.RUnlock()
 
 	res, err := db.QueryContext(ctx, `
-    SELECT rowid, source_id, updated_at, url, data, name, condensed_fields, pin_count, condensed_pins FROM symbols WHERE url = ?;
+    SELECT rowid, source_id, updated_at, url, data, name, condensed_fields, pin_count, condensed_pins, description, keywords, datasheet, units, power, de_morgan FROM symbols WHERE url = ?;
   `, url)
 	if err != nil {
 		return nil, err
@@ -163,35 +361,47 @@ func SymbolByURL(ctx context.Context, url string, db *sql.DB) (*Symbol, error) {
 		return nil, os.ErrNotExist
 	}
 	var s Symbol
-	return &s, res.Scan(&s.UID, &s.SourceID, &s.UpdatedAt, &s.URL, &s.Data, &s.Name, &s.FieldData, &s.PinCount, &s.PinData)
+	return &s, res.Scan(&s.UID, &s.SourceID, &s.UpdatedAt, &s.URL, &s.Data, &s.Name, &s.FieldData, &s.PinCount, &s.PinData, &s.Description, &s.Keywords, &s.Datasheet, &s.Units, &s.Power, &s.DeMorgan)
 }
 
 // SymSearchParam specifies parameters to constrain a symbol search.
 type SymSearchParam struct {
 	Keywords []string
 	PinCount int
+	// Units, if non-zero, limits results to symbols with that many units.
+	Units int
+	// Power, if set, limits results to power symbols or to other symbols.
+	Power *bool
+	// Limit is the maximum number of results, or 65 if zero.
+	Limit int
 }
 
 // SymbolSearch performs a symbol search.
 func SymbolSearch(ctx context.Context, search SymSearchParam, db *sql.DB) ([]*Symbol, error) {
-	where := ""
+	var conds []string
 	params := []interface{}{}
-	for i, kw := range search.Keywords {
-		where += "(name LIKE ? OR condensed_fields LIKE ? OR condensed_pins LIKE ?)"
-		params = append(params, "%"+kw+"%", "%"+kw+"%", "%"+kw+"%")
-		if i < (len(search.Keywords) - 1) {
-			where += " AND "
-		}
+	for _, kw := range search.Keywords {
+		conds = append(conds, "(name LIKE ? OR condensed_fields LIKE ? OR condensed_pins LIKE ? OR description LIKE ? OR keywords LIKE ?)")
+		params = append(params, "%"+kw+"%", "%"+kw+"%", "%"+kw+"%", "%"+kw+"%", "%"+kw+"%")
 	}
 	if search.PinCount != 0 {
-		where += " AND pin_count = ?"
+		conds = append(conds, "pin_count = ?")
 		params = append(params, search.PinCount)
 	}
+	if search.Units != 0 {
+		conds = append(conds, "units = ?")
+		params = append(params, search.Units)
+	}
+	if search.Power != nil {
+		conds = append(conds, "power = ?")
+		params = append(params, *search.Power)
+	}
+	where := strings.Join(conds, " AND ")
 
 	dbLock.RLock()
 	defer dbLock.RUnlock()
 
-	res, err := db.QueryContext(ctx, "SELECT rowid, source_id, updated_at, url, name, pin_count FROM symbols WHERE "+where+" LIMIT 65;", params...)
+	res, err := db.QueryContext(ctx, "SELECT rowid, source_id, updated_at, url, name, pin_count, content_hash, description, units, power FROM symbols WHERE "+where+" LIMIT ?;", append(params, searchLimit(search.Limit))...)
 	if err != nil {
 		fmt.Printf("db.QueryContext(%q) failed: %v\n", "... WHERE "+where, err)
 		return nil, err
@@ -201,7 +411,7 @@ func SymbolSearch(ctx context.Context, search SymSearchParam, db *sql.DB) ([]*Sy
 	var out []*Symbol
 	for res.Next() {
 		var sym Symbol
-		if err := res.Scan(&sym.UID, &sym.SourceID, &sym.UpdatedAt, &sym.URL, &sym.Name, &sym.PinCount); err != nil {
+		if err := res.Scan(&sym.UID, &sym.SourceID, &sym.UpdatedAt, &sym.URL, &sym.Name, &sym.PinCount, &sym.ContentHash, &sym.Description, &sym.Units, &sym.Power); err != nil {
 			fmt.Printf("db.Scan(%q) failed: %v\n", "... WHERE "+where, err)
 			return nil, err
 		}
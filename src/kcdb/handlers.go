@@ -4,18 +4,56 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"kcdb/admin"
 	"kcdb/db"
 	"kcdb/ingestor"
 	"kcdb/sym"
 	"kcdb/search"
+	"kcdb/webhook"
 
 	"github.com/twitchyliquid64/kcgen/pcb"
 )
 
+// visible returns true if parts from the source with the given UID may be
+// served in response to req, otherwise replying with an error. Private sources
+// and their parts are only served to requests carrying the admin secret.
+func visible(w http.ResponseWriter, req *http.Request, sourceUID int) bool {
+	if admin.Authorized(req) {
+		return true
+	}
+	s, err := db.GetSource(req.Context(), sourceUID, db.DB())
+	switch {
+	case err == os.ErrNotExist:
+		return true
+	case err != nil:
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return false
+	case s.Private:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// publicSources returns the sources which are not private.
+func publicSources(sources []*db.Source) []*db.Source {
+	out := make([]*db.Source, 0, len(sources))
+	for _, s := range sources {
+		if !s.Private {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
 // SearchHandler performs a search.
 func SearchHandler(w http.ResponseWriter, req *http.Request) {
 	var query struct {
@@ -32,9 +70,9 @@ func SearchHandler(w http.ResponseWriter, req *http.Request) {
 	var err error
 
 	if query.SymbolsQuery {
-		results, err = search.SymbolSearch(req.Context(), query.Query)
+		results, err = search.SymbolSearch(req.Context(), query.Query, admin.Authorized(req))
 	} else {
-		results, err = search.Search(req.Context(), query.Query)
+		results, err = search.Search(req.Context(), query.Query, admin.Authorized(req))
 	}
 	if err != nil {
 		if _, badQuery := err.(search.ErrBadQuery); badQuery {
@@ -88,6 +126,7 @@ func SymbolHandler(w http.ResponseWriter, req *http.Request) {
 // SymbolDetails replies with a JSON blob representing the Symbol.
 func SymbolDetails(w http.ResponseWriter, req *http.Request) {
 	var raw []byte
+	var doc *sym.Doc
 	if strings.HasPrefix(req.URL.Path, "/sym/details/") {
 		fp, err := db.SymbolByURL(req.Context(), req.URL.Path[len("/sym/details/"):], db.DB())
 		if err != nil {
@@ -99,17 +138,25 @@ func SymbolDetails(w http.ResponseWriter, req *http.Request) {
 			fmt.Printf("Err: %v\n", err)
 			return
 		}
+		if !visible(w, req, fp.SourceID) {
+			return
+		}
 		raw = fp.Data
+		// Documentation from a .dcm file is not part of the stored data.
+		doc = &sym.Doc{Name: fp.Name, Description: fp.Description, Keywords: fp.Keywords, Datasheet: fp.Datasheet}
 	} else {
 		http.Error(w, "The request did not indicate what symbol should be returned", http.StatusBadRequest)
 		return
 	}
-	mod, err := sym.DecodeSymbolLibrary(strings.NewReader("EESchema-LIBRARY Version 2.KEK\n" + string(raw)))
+	mod, err := sym.DecodeStoredSymbol(raw)
 	if err != nil {
 		http.Error(w, "Internal error", http.StatusInternalServerError)
 		fmt.Printf("Err: %v\n", err)
 		return
 	}
+	if len(mod) > 0 {
+		mod[0].Document(doc)
+	}
 	b, err := json.Marshal(mod)
 	if err != nil {
 		http.Error(w, "Internal error", http.StatusInternalServerError)
@@ -135,6 +182,9 @@ func ModuleDetails(w http.ResponseWriter, req *http.Request) {
 			fmt.Printf("Err: %v\n", err)
 			return
 		}
+		if !visible(w, req, fp.SourceID) {
+			return
+		}
 		raw = fp.Data
 	} else {
 		http.Error(w, "The request did not indicate what footprint should be returned", http.StatusBadRequest)
@@ -158,7 +208,34 @@ func ModuleDetails(w http.ResponseWriter, req *http.Request) {
 	w.Write(b)
 }
 
-// ListSources responds with a list of sources.
+// ModuleDownload replies with the footprint as a .kicad_mod file. Footprints
+// from legacy libraries and boards are stored converted to that format.
+func ModuleDownload(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, "/module/download/") {
+		http.Error(w, "The request did not indicate what footprint should be returned", http.StatusBadRequest)
+		return
+	}
+	fp, err := db.FootprintByURL(req.Context(), req.URL.Path[len("/module/download/"):], db.DB())
+	if err != nil {
+		if err == os.ErrNotExist {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	if !visible(w, req, fp.SourceID) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fp.Name+".kicad_mod"))
+	w.Write(fp.Data)
+}
+
+// ListSources responds with a list of sources. Private sources are only
+// listed for requests carrying the admin secret.
 func ListSources(w http.ResponseWriter, req *http.Request) {
 	sources, err := db.GetSources(req.Context(), db.DB())
 	if err != nil {
@@ -166,6 +243,18 @@ func ListSources(w http.ResponseWriter, req *http.Request) {
 		fmt.Printf("Err: %v\n", err)
 		return
 	}
+	if !admin.Authorized(req) {
+		sources = publicSources(sources)
+	}
+	counts, err := db.IngestErrorCounts(req.Context(), db.DB())
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	for _, s := range sources {
+		s.ErrorCount = counts[s.UID]
+	}
 	b, err := json.Marshal(sources)
 	if err != nil {
 		http.Error(w, "Internal error", http.StatusInternalServerError)
@@ -177,7 +266,108 @@ func ListSources(w http.ResponseWriter, req *http.Request) {
 	w.Write(b)
 }
 
-// IngestState responds with the current state of the ingestor.
+// SourceErrors responds with the files of a source which could not be
+// ingested. The path is of the form /sources/{uid}/errors.
+func SourceErrors(w http.ResponseWriter, req *http.Request) {
+	spl := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(spl) != 3 || spl[2] != "errors" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	uid, err := strconv.Atoi(spl[1])
+	if err != nil {
+		http.Error(w, "Bad source", http.StatusBadRequest)
+		return
+	}
+	if !visible(w, req, uid) {
+		return
+	}
+
+	errs, err := db.GetIngestErrors(req.Context(), uid, db.DB())
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	b, err := json.Marshal(errs)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// WebhookHandler accepts push notifications from git hosting services, and
+// queues any matching sources for ingestion.
+func WebhookHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if webhook.Provider(req.Header) == "" {
+		http.Error(w, "Unrecognised webhook", http.StatusBadRequest)
+		return
+	}
+	if !webhook.IsPush(req.Header) {
+		// Hosts send other events such as pings when a hook is set up.
+		w.Write([]byte("Ignored."))
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, 4*1024*1024))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	push, err := webhook.Decode(req.Header, body)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+
+	sources, err := db.GetSources(req.Context(), db.DB())
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	var matched, verified, queued int
+	for _, s := range sources {
+		if s.Kind != db.SourceKindGit || !push.Matches(s.URL) {
+			continue
+		}
+		matched++
+		if err := webhook.Verify(req.Header, body, s.WebhookSecret); err != nil {
+			continue
+		}
+		verified++
+		meta, err := s.ParseMetadata()
+		if err != nil || !push.Affects(meta.Ref) {
+			continue
+		}
+		fmt.Printf("[webhook] Queueing %s (%d) after push to %s.\n", s.URL, s.UID, push.Ref)
+		ingestor.Enqueue(s.UID)
+		queued++
+	}
+
+	switch {
+	case matched == 0:
+		http.Error(w, "No matching source", http.StatusNotFound)
+	case verified == 0:
+		http.Error(w, "Not Authorized", http.StatusUnauthorized)
+	case queued == 0:
+		// The push was to a branch or tag which no source tracks.
+		w.Write([]byte("Ignored."))
+	default:
+		w.Write([]byte("OK."))
+	}
+}
+
+// IngestState responds with the current state of the ingestor. Private
+// sources are only reported to requests carrying the admin secret.
 func IngestState(w http.ResponseWriter, req *http.Request) {
 	next, err := ingestor.ComputeIngestTargets()
 	if err != nil {
@@ -185,12 +375,52 @@ func IngestState(w http.ResponseWriter, req *http.Request) {
 		fmt.Printf("Err: %v\n", err)
 		return
 	}
-	current, delay, nextIngest := ingestor.State()
+	inFlight, workers, delay, nextIngest := ingestor.State()
+	last, queued := ingestor.LastResult(), ingestor.Queue()
+
+	if !admin.Authorized(req) {
+		sources, err := db.GetSources(req.Context(), db.DB())
+		if err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+		private := map[int]bool{}
+		for _, s := range sources {
+			private[s.UID] = s.Private
+		}
+		hideResult := func(r *ingestor.Result) *ingestor.Result {
+			if r != nil && private[r.SourceUID] {
+				return nil
+			}
+			return r
+		}
+
+		inFlight, next = publicSources(inFlight), publicSources(next)
+		for i := range workers {
+			if c := workers[i].Current; c != nil && c.Private {
+				workers[i].Current = nil
+			}
+			workers[i].LastResult = hideResult(workers[i].LastResult)
+		}
+		last = hideResult(last)
+		var public []int
+		for _, uid := range queued {
+			if !private[uid] {
+				public = append(public, uid)
+			}
+		}
+		queued = public
+	}
+
 	b, err := json.Marshal(map[string]interface{}{
-		"current":              current,
+		"in_flight":            inFlight,
+		"workers":              workers,
 		"ingest_delay_seconds": delay,
 		"next_ingest":          nextIngest,
 		"next_sources":         next,
+		"last_result":          last,
+		"queued":               queued,
 	})
 	if err != nil {
 		http.Error(w, "Internal error", http.StatusInternalServerError)
@@ -201,3 +431,155 @@ func IngestState(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
 }
+
+// ModuleHistory replies with a JSON list of the revisions of a footprint. If
+// the rev parameter is set, it replies with that revision of the Module.
+func ModuleHistory(w http.ResponseWriter, req *http.Request) {
+	fp, err := db.FootprintByURL(req.Context(), strings.TrimPrefix(req.URL.Path, "/module/history/"), db.DB())
+	if err != nil {
+		if err == os.ErrNotExist {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	if !visible(w, req, fp.SourceID) {
+		return
+	}
+
+	var out interface{}
+	if rev := req.FormValue("rev"); rev != "" {
+		uid, err := strconv.Atoi(rev)
+		if err != nil {
+			http.Error(w, "Bad revision", http.StatusBadRequest)
+			return
+		}
+		r, err := db.FootprintRevision(req.Context(), fp.UID, uid, db.DB())
+		if err != nil {
+			if err == os.ErrNotExist {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+			}
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+		if out, err = pcb.ParseModule(strings.NewReader(string(r.Data))); err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+	} else {
+		if out, err = db.FootprintRevisions(req.Context(), fp.UID, db.DB()); err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// SymbolHistory replies with a JSON list of the revisions of a symbol. If
+// the rev parameter is set, it replies with that revision of the Symbol.
+func SymbolHistory(w http.ResponseWriter, req *http.Request) {
+	s, err := db.SymbolByURL(req.Context(), strings.TrimPrefix(req.URL.Path, "/sym/history/"), db.DB())
+	if err != nil {
+		if err == os.ErrNotExist {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	if !visible(w, req, s.SourceID) {
+		return
+	}
+
+	var out interface{}
+	if rev := req.FormValue("rev"); rev != "" {
+		uid, err := strconv.Atoi(rev)
+		if err != nil {
+			http.Error(w, "Bad revision", http.StatusBadRequest)
+			return
+		}
+		r, err := db.SymbolRevision(req.Context(), s.UID, uid, db.DB())
+		if err != nil {
+			if err == os.ErrNotExist {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+			}
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+		if out, err = sym.DecodeStoredSymbol(r.Data); err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+	} else {
+		if out, err = db.SymbolRevisions(req.Context(), s.UID, db.DB()); err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			fmt.Printf("Err: %v\n", err)
+			return
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// IngestEvents streams the progress of ingests to the client as server-sent
+// events, until the client disconnects.
+func IngestEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := ingestor.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep proxies from closing the connection while idle.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-events:
+			b, err := json.Marshal(e)
+			if err != nil {
+				fmt.Printf("Err: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+		}
+		flusher.Flush()
+	}
+}
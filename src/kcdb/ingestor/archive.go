@@ -0,0 +1,238 @@
+package ingestor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"kcdb/db"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var archiveClient = &http.Client{}
+
+// archiveSnapshot downloads and extracts a zip or tar.gz archive source into
+// the scratch directory. The archive is only extracted if its content differs
+// from the archive which was last ingested.
+func archiveSnapshot(source *db.Source, meta *db.SourceMetadata, scratch string) (*snapshot, error) {
+	if err := os.RemoveAll(scratch); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(scratch, 0755); err != nil {
+		return nil, err
+	}
+
+	budget := newSizeBudget()
+	archivePath := source.URL
+	if strings.HasPrefix(source.URL, "http://") || strings.HasPrefix(source.URL, "https://") {
+		archivePath = filepath.Join(scratch, "archive")
+		if err := download(source, archivePath, budget); err != nil {
+			return nil, err
+		}
+	}
+
+	sum, err := hashFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{version: sum, changed: []string{}}
+	if sum == source.LastCommit {
+		fmt.Printf("[ingest][archive] %s has not changed since it was last ingested.\n", source.URL)
+		return snap, nil
+	}
+
+	extracted := filepath.Join(scratch, "extracted")
+	if err := extractArchive(archivePath, extracted, budget); err != nil {
+		return nil, err
+	}
+	// Archives commonly contain a single versioned top-level directory. Ingest
+	// from within it so part URLs remain the same across releases.
+	if snap.root, err = singleSubdir(extracted); err != nil {
+		return nil, err
+	}
+	snap.full = true
+	if snap.changed, err = walkFiles(snap.root, meta.Subdir); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func download(source *db.Source, dest string, budget *sizeBudget) error {
+	ctx, cancel := fetchContext()
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, source.URL, nil)
+	if err != nil {
+		return err
+	}
+	if err := setRequestAuth(req, source); err != nil {
+		return err
+	}
+	resp, err := archiveClient.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return limitErrorf("downloading took longer than %v", limits.FetchTimeout)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading archive: unexpected status %q", resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if err := budget.copy(f, resp.Body); err != nil {
+		f.Close()
+		if ctx.Err() == context.DeadlineExceeded {
+			return limitErrorf("downloading took longer than %v", limits.FetchTimeout)
+		}
+		return err
+	}
+	return f.Close()
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractArchive extracts the zip or gzipped tarball at p into dest. The
+// format is detected from the content of the file.
+func extractArchive(p, dest string, budget *sizeBudget) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic, err := bufio.NewReader(f).Peek(4)
+	if err != nil {
+		return fmt.Errorf("reading archive: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		return extractZip(p, dest, budget)
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTar(gz, dest, budget)
+	}
+	return errors.New("unrecognised archive format: expected zip or tar.gz")
+}
+
+// archiveDest returns where the archive entry called name should be extracted
+// to, or an error if the name would escape dest.
+func archiveDest(dest, name string) (string, error) {
+	clean := path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+	if clean == "/" {
+		return "", fmt.Errorf("bad archive entry name %q", name)
+	}
+	return filepath.Join(dest, filepath.FromSlash(clean[1:])), nil
+}
+
+func writeArchiveFile(p string, r io.Reader, budget *sizeBudget) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if err := budget.copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func extractZip(p, dest string, budget *sizeBudget) error {
+	z, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() || !f.Mode().IsRegular() {
+			continue
+		}
+		out, err := archiveDest(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(out, r, budget)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(r io.Reader, dest string, budget *sizeBudget) error {
+	t := tar.NewReader(r)
+	for {
+		hdr, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		out, err := archiveDest(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := writeArchiveFile(out, t, budget); err != nil {
+			return err
+		}
+	}
+}
+
+// singleSubdir returns the path of the only entry in dir if it is a directory,
+// otherwise dir itself.
+func singleSubdir(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
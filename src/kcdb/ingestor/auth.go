@@ -0,0 +1,64 @@
+package ingestor
+
+import (
+	"fmt"
+	"kcdb/creds"
+	"kcdb/db"
+	"net/http"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// sourceCredentials decrypts the credentials of a source, returning nil if
+// it has none. Errors never include the credentials themselves.
+func sourceCredentials(source *db.Source) (*creds.Credentials, error) {
+	if source.Credentials == "" {
+		return nil, nil
+	}
+	c, err := creds.Open(source.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("source credentials: %v", err)
+	}
+	return c, nil
+}
+
+// gitAuth returns the authentication to use when fetching a git source, or
+// nil if the source has no credentials. SSH host keys are checked against the
+// known_hosts file of the user running kcdb.
+func gitAuth(source *db.Source) (transport.AuthMethod, error) {
+	c, err := sourceCredentials(source)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	user := c.Username
+	if user == "" {
+		user = "git"
+	}
+
+	switch c.Kind {
+	case creds.KindBasic:
+		return &githttp.BasicAuth{Username: user, Password: c.Password}, nil
+	case creds.KindSSH:
+		auth, err := gitssh.NewPublicKeys(user, []byte(c.PrivateKey), c.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("source credentials: bad ssh key: %v", err)
+		}
+		return auth, nil
+	}
+	return nil, fmt.Errorf("source credentials: cannot use %s credentials for git", c.Kind)
+}
+
+// setRequestAuth adds the credentials of a source to an HTTP request.
+func setRequestAuth(req *http.Request, source *db.Source) error {
+	c, err := sourceCredentials(source)
+	if err != nil || c == nil {
+		return err
+	}
+	if c.Kind != creds.KindBasic {
+		return fmt.Errorf("source credentials: cannot use %s credentials for http downloads", c.Kind)
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	return nil
+}
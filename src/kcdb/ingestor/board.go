@@ -0,0 +1,332 @@
+package ingestor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"kcdb/db"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/twitchyliquid64/kcgen/pcb"
+)
+
+// boardFile is a board which is ingested once every library in the pass has
+// been, so footprints which are copies of a library footprint can be skipped.
+type boardFile struct {
+	origin *origin
+	path   string
+}
+
+// boardFootprint is a footprint extracted from a board.
+type boardFootprint struct {
+	mod  *pcb.Module
+	data []byte
+	hash string
+}
+
+// ingestBoards extracts the footprints from the boards queued by ingestFile.
+func (p *ingestPass) ingestBoards() error {
+	for _, b := range p.boards {
+		if err := p.ingestBoard(b.origin, b.path); err != nil {
+			return err
+		}
+	}
+	p.boards = nil
+	return nil
+}
+
+// ingestBoard stores the unique footprints placed on the board at path. Parts
+// are named after the board file and the footprint, and footprints which are
+// identical to one from a library are skipped.
+func (p *ingestPass) ingestBoard(o *origin, path string) error {
+	fullPath := filepath.Join(p.root, path)
+	b, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return err
+	}
+	boardPath := strings.TrimPrefix(path, o.prefix)
+	url := db.MakePartURL(o.url, boardPath)
+	p.scope = append(p.scope, url)
+	p.paths = append(p.paths, path)
+
+	if err := checkParseDepth(b); err != nil {
+		return err
+	}
+	var board *pcb.PCB
+	err = withParseTimeout(p.source.UID, path, func() (err error) {
+		board, err = pcb.DecodeFile(fullPath)
+		return err
+	})
+	if abortsIngest(err) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(p.log, "[ingest][board] Failed parsing %q: %v\n", path, err)
+		p.fail(o, path, url, err)
+		return nil
+	}
+
+	fps, err := boardFootprints(board)
+	if err != nil {
+		fmt.Fprintf(p.log, "[ingest][board] Failed extracting footprints from %q: %v\n", path, err)
+		p.fail(o, path, url, err)
+		return nil
+	}
+	for name, fp := range fps {
+		dup, err := p.sink.libraryFootprint(fp.hash)
+		if err != nil {
+			return err
+		}
+		if dup {
+			continue
+		}
+		p.seen[url+"::"+name] = true
+		if err := p.sink.footprint(o, url+"::"+name, fp.data, fp.mod, fp.hash, boardPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boardFootprints returns the distinct footprints placed on the board, keyed
+// by a name which is unique within the board.
+func boardFootprints(board *pcb.PCB) (map[string]*boardFootprint, error) {
+	out := map[string]*boardFootprint{}
+	seen := map[string]bool{}
+	for i := range board.Modules {
+		m := unplaced(&board.Modules[i])
+		hash, err := canonicalHash(m)
+		if err != nil {
+			return nil, err
+		}
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		var buf bytes.Buffer
+		if err := m.WriteModule(&buf); err != nil {
+			return nil, err
+		}
+		name := m.Name
+		if _, taken := out[name]; taken {
+			name += "@" + hash[:8]
+		}
+		out[name] = &boardFootprint{mod: m, data: buf.Bytes(), hash: hash}
+	}
+	return out, nil
+}
+
+// unplaced returns a copy of the module with its placement on the board, net
+// assignments and timestamps removed. Footprints on the back of the board are
+// flipped back to the front. The module itself is not modified.
+func unplaced(m *pcb.Module) *pcb.Module {
+	out := *m
+	rotation := m.Placement.At.Z
+	out.Placement = pcb.ModPlacement{}
+	out.Placed, out.Locked = false, false
+	out.Tedit, out.Tstamp, out.Path = "", "", ""
+	// KiCad 6+ records the schematic sheet of each placed footprint.
+	out.Properties = nil
+	for _, prop := range m.Properties {
+		if prop.Name != "Sheetfile" && prop.Name != "Sheetname" {
+			out.Properties = append(out.Properties, prop)
+		}
+	}
+
+	// Boards record the angle of pads and text including the rotation of
+	// the footprint.
+	out.Pads = make([]pcb.Pad, len(m.Pads))
+	for i, pad := range m.Pads {
+		pad.NetNum, pad.NetName = 0, ""
+		pad.At = unrotate(pad.At, rotation)
+		out.Pads[i] = pad
+	}
+	out.Graphics = make([]pcb.ModGraphic, len(m.Graphics))
+	for i, g := range m.Graphics {
+		if t, ok := g.Renderable.(*pcb.ModText); ok {
+			text := *t
+			text.At = unrotate(text.At, rotation)
+			if text.Kind == pcb.RefText {
+				text.Text = "REF**"
+			}
+			g.Renderable = &text
+		}
+		out.Graphics[i] = g
+	}
+	if strings.HasPrefix(m.Layer, "B.") {
+		unmirror(&out)
+	}
+	return &out
+}
+
+// unmirror flips a footprint placed on the back of the board to the front,
+// undoing what KiCad does when a footprint is flipped: the Y axis and angles
+// are negated, layers are swapped between sides and text is mirrored. The
+// graphics and pads are copied before they are changed.
+func unmirror(m *pcb.Module) {
+	m.Layer = flipLayer(m.Layer)
+	graphics := make([]pcb.ModGraphic, len(m.Graphics))
+	for i, g := range m.Graphics {
+		graphics[i] = mirrorGraphic(g)
+	}
+	m.Graphics = graphics
+
+	pads := make([]pcb.Pad, len(m.Pads))
+	for i, pad := range m.Pads {
+		pad.At = mirrorXYZ(pad.At)
+		pad.RectDelta.Y = negate(pad.RectDelta.Y)
+		pad.DrillOffset.Y = negate(pad.DrillOffset.Y)
+		pad.Layers = flipLayers(pad.Layers)
+		if len(pad.Primitives) > 0 {
+			prims := make([]pcb.ModGraphic, len(pad.Primitives))
+			for j, g := range pad.Primitives {
+				prims[j] = mirrorGraphic(g)
+			}
+			pad.Primitives = prims
+		}
+		pads[i] = pad
+	}
+	m.Pads = pads
+
+	zones := make([]pcb.Zone, len(m.Zones))
+	for i, z := range m.Zones {
+		z.Layers = flipLayers(z.Layers)
+		polys := make([][]pcb.XY, len(z.Polys))
+		for j, poly := range z.Polys {
+			polys[j] = mirrorPoints(poly)
+		}
+		z.Polys = polys
+		zones[i] = z
+	}
+	m.Zones = zones
+}
+
+// mirrorGraphic returns a copy of g flipped about the X axis and moved to the
+// other side of the board.
+func mirrorGraphic(g pcb.ModGraphic) pcb.ModGraphic {
+	switch r := g.Renderable.(type) {
+	case *pcb.ModText:
+		t := *r
+		t.At = mirrorXYZ(t.At)
+		t.Layer = flipLayer(t.Layer)
+		if t.Effects.Justify == pcb.JustifyMirror {
+			t.Effects.Justify = pcb.JustifyNone
+		}
+		g.Renderable = &t
+	case *pcb.ModLine:
+		l := *r
+		l.Start.Y, l.End.Y = negate(l.Start.Y), negate(l.End.Y)
+		l.Layer = flipLayer(l.Layer)
+		g.Renderable = &l
+	case *pcb.ModCircle:
+		c := *r
+		c.Center.Y, c.End.Y = negate(c.Center.Y), negate(c.End.Y)
+		c.Layer = flipLayer(c.Layer)
+		g.Renderable = &c
+	case *pcb.ModRect:
+		rect := *r
+		rect.Start.Y, rect.End.Y = negate(rect.Start.Y), negate(rect.End.Y)
+		rect.Layer = flipLayer(rect.Layer)
+		g.Renderable = &rect
+	case *pcb.ModArc:
+		a := *r
+		a.Start.Y, a.End.Y = negate(a.Start.Y), negate(a.End.Y)
+		a.Angle = negate(a.Angle)
+		a.Layer = flipLayer(a.Layer)
+		g.Renderable = &a
+	case *pcb.ModPolygon:
+		p := *r
+		p.At.Y = negate(p.At.Y)
+		p.Points = mirrorPoints(p.Points)
+		p.Layer = flipLayer(p.Layer)
+		g.Renderable = &p
+	case *pcb.ModCurve:
+		c := *r
+		c.Points = mirrorPoints(c.Points)
+		c.Layer = flipLayer(c.Layer)
+		g.Renderable = &c
+	}
+	return g
+}
+
+// negate returns -v, without producing a negative zero which would be
+// written as -0.
+func negate(v float64) float64 {
+	return 0 - v
+}
+
+func mirrorXYZ(at pcb.XYZ) pcb.XYZ {
+	at.Y = negate(at.Y)
+	at.Z = math.Mod(360-at.Z, 360)
+	at.ZPresent = at.Z != 0
+	return at
+}
+
+func mirrorPoints(pts []pcb.XY) []pcb.XY {
+	out := make([]pcb.XY, len(pts))
+	for i, pt := range pts {
+		out[i] = pcb.XY{X: pt.X, Y: negate(pt.Y)}
+	}
+	return out
+}
+
+// flipLayer returns the layer on the other side of the board to l, or l if
+// it is not specific to one side.
+func flipLayer(l string) string {
+	switch {
+	case strings.HasPrefix(l, "B."):
+		return "F." + l[2:]
+	case strings.HasPrefix(l, "F."):
+		return "B." + l[2:]
+	}
+	return l
+}
+
+func flipLayers(layers []string) []string {
+	out := make([]string, len(layers))
+	for i, l := range layers {
+		out[i] = flipLayer(l)
+	}
+	return out
+}
+
+func unrotate(at pcb.XYZ, rotation float64) pcb.XYZ {
+	at.Z = math.Mod(at.Z-rotation+720, 360)
+	at.ZPresent = at.Z != 0
+	return at
+}
+
+// canonicalHash returns a hash of the module which is the same for a library
+// footprint and any unmodified copy of it placed on a board, once the copy has
+// been passed through unplaced. Library footprints are hashed as they are, so
+// graphics they deliberately place on the back are kept. The module itself is
+// not modified.
+func canonicalHash(m *pcb.Module) (string, error) {
+	c := *m
+	c.Tedit, c.Tstamp, c.Path = "", "", ""
+	// Boards prefix the name with the library it came from, and the value
+	// text holds the value of the component.
+	if idx := strings.LastIndex(c.Name, ":"); idx >= 0 {
+		c.Name = c.Name[idx+1:]
+	}
+	c.Graphics = make([]pcb.ModGraphic, len(m.Graphics))
+	for i, g := range m.Graphics {
+		if t, ok := g.Renderable.(*pcb.ModText); ok && t.Kind == pcb.ValueText {
+			text := *t
+			text.Text = c.Name
+			g.Renderable = &text
+		}
+		c.Graphics[i] = g
+	}
+
+	h := sha256.New()
+	if err := c.WriteModule(h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,52 @@
+package ingestor
+
+import (
+	"context"
+	"kcdb/db"
+	"os"
+	"path/filepath"
+)
+
+// dirSnapshot scans a directory source, finding files which were added, modified
+// or removed since the previous scan by comparing their size and modification time.
+func dirSnapshot(source *db.Source, meta *db.SourceMetadata) (*snapshot, error) {
+	ctx := context.Background()
+	root := source.URL
+	paths, err := walkFiles(root, meta.Subdir)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := db.GetSourceFiles(ctx, source.UID, db.DB())
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{root: root, full: len(prev) == 0, changed: []string{}}
+	files := map[string]db.SourceFile{}
+	for _, p := range paths {
+		if !ingestable(p) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, err
+		}
+		f := db.SourceFile{Size: info.Size(), ModTime: info.ModTime()}
+		files[p] = f
+
+		old, seen := prev[p]
+		if snap.full || !seen || old.Size != f.Size || !old.ModTime.Equal(f.ModTime) {
+			snap.changed = append(snap.changed, p)
+		}
+	}
+	for p := range prev {
+		if _, exists := files[p]; !exists {
+			snap.removed = append(snap.removed, p)
+		}
+	}
+
+	snap.done = func() error {
+		return db.SetSourceFiles(ctx, source.UID, files, db.DB())
+	}
+	return snap, nil
+}
@@ -0,0 +1,179 @@
+package ingestor
+
+import (
+	"fmt"
+	"io"
+	"kcdb/db"
+	"kcdb/sym"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/twitchyliquid64/kcgen/pcb"
+)
+
+// Report describes the parts which would be ingested from a directory.
+type Report struct {
+	Root string `json:"root"`
+	// URL is the location the parts are attributed to.
+	URL   string `json:"url"`
+	Files int    `json:"files"`
+
+	Parts      []*ReportPart    `json:"parts"`
+	Failures   []*ReportFailure `json:"failures"`
+	Duplicates []*Duplicate     `json:"duplicates"`
+	// Referenced lists the repositories referenced by library tables.
+	Referenced []string `json:"referenced"`
+}
+
+// ReportPart describes a part which would be ingested.
+type ReportPart struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Board is the path of the board a footprint was extracted from.
+	Board string `json:"board,omitempty"`
+}
+
+// ReportFailure describes a file which could not be parsed.
+type ReportFailure struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Duplicate describes parts of the same kind which share a name.
+type Duplicate struct {
+	Kind string   `json:"kind"`
+	Name string   `json:"name"`
+	URLs []string `json:"urls"`
+}
+
+// Part kinds in a report.
+const (
+	PartFootprint = "footprint"
+	PartSymbol    = "symbol"
+)
+
+// reportSink records the parts found by a pass in a report.
+type reportSink struct {
+	report *Report
+	// libHashes contains the content hashes of library footprints.
+	libHashes map[string]bool
+}
+
+func (sk *reportSink) footprint(o *origin, url string, b []byte, fp *pcb.Module, hash, board string) error {
+	if board == "" {
+		sk.libHashes[hash] = true
+	}
+	sk.report.Parts = append(sk.report.Parts, &ReportPart{Kind: PartFootprint, Name: fp.Name, URL: url, Board: board})
+	return nil
+}
+
+func (sk *reportSink) symbol(o *origin, url string, b []byte, s *sym.Symbol) error {
+	sk.report.Parts = append(sk.report.Parts, &ReportPart{Kind: PartSymbol, Name: s.Name, URL: url})
+	return nil
+}
+
+// libraryFootprint only considers footprints from the directory, as the
+// database is not consulted.
+func (sk *reportSink) libraryFootprint(hash string) (bool, error) {
+	return sk.libHashes[hash], nil
+}
+
+func (sk *reportSink) registered(repoURL string) (bool, error) {
+	return false, nil
+}
+
+// DryRun parses the parts in the directory at root as they would be when
+// ingesting it as a source, without modifying the database. Parts are
+// attributed to url, or to the directory if url is empty. Messages about files
+// which could not be parsed are written to log.
+func DryRun(root, url string, log io.Writer) (*Report, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", root)
+	}
+	if url == "" {
+		url = root
+	}
+	paths, err := walkFiles(root, "")
+	if err != nil {
+		return nil, err
+	}
+
+	source := &db.Source{Kind: db.SourceKindDir, URL: url}
+	report := &Report{Root: root, URL: url}
+	pass := newIngestPass(source, root)
+	pass.full = true
+	pass.sink = &reportSink{report: report, libHashes: map[string]bool{}}
+	pass.log = log
+	o := &origin{url: url}
+
+	for _, p := range paths {
+		if !ingestable(p) {
+			continue
+		}
+		report.Files++
+		if limits.MaxFiles > 0 && report.Files > limits.MaxFiles {
+			return nil, limitErrorf("source has more than %d files", limits.MaxFiles)
+		}
+		if err := pass.ingestFile(o, p); err != nil {
+			return nil, err
+		}
+	}
+	if err := pass.ingestBoards(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range pass.errs {
+		report.Failures = append(report.Failures, &ReportFailure{Path: e.Path, Message: e.Message})
+	}
+	seen := map[string]bool{}
+	for _, c := range pass.candidates {
+		if !seen[c.URL] {
+			seen[c.URL] = true
+			report.Referenced = append(report.Referenced, c.URL)
+		}
+	}
+	sort.Strings(report.Referenced)
+	report.Duplicates = duplicates(report.Parts)
+	return report, nil
+}
+
+// duplicates returns the names which are shared by parts of the same kind.
+// Footprints extracted from boards are not considered, as boards commonly
+// contain variants of the same footprint.
+func duplicates(parts []*ReportPart) []*Duplicate {
+	var out []*Duplicate
+	byName := map[[2]string]*Duplicate{}
+	for _, p := range parts {
+		if p.Board != "" {
+			continue
+		}
+		key := [2]string{p.Kind, p.Name}
+		d, ok := byName[key]
+		if !ok {
+			d = &Duplicate{Kind: p.Kind, Name: p.Name}
+			byName[key] = d
+			out = append(out, d)
+		}
+		d.URLs = append(d.URLs, p.URL)
+	}
+
+	dups := out[:0]
+	for _, d := range out {
+		if len(d.URLs) > 1 {
+			dups = append(dups, d)
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		if dups[i].Kind != dups[j].Kind {
+			return dups[i].Kind < dups[j].Kind
+		}
+		return dups[i].Name < dups[j].Name
+	})
+	return dups
+}
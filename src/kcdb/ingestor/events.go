@@ -0,0 +1,141 @@
+package ingestor
+
+import (
+	"bytes"
+	"kcdb/db"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types, in the order they occur during an ingest.
+const (
+	// EventStart is sent when a worker begins ingesting a source.
+	EventStart = "start"
+	// EventFetch carries progress output from the git server while a
+	// repository is cloned or fetched.
+	EventFetch = "fetch"
+	// EventFiles is sent once the files which need ingesting are known.
+	EventFiles = "files"
+	// EventProgress is sent periodically as files are parsed.
+	EventProgress = "progress"
+	// EventPruned is sent once parts which no longer exist are removed.
+	EventPruned = "pruned"
+	// EventVacuum is sent when the database is vacuumed after an ingest.
+	EventVacuum = "vacuum"
+	// EventFinish is sent when the ingest completes, successfully or not.
+	EventFinish = "finish"
+)
+
+// progressInterval limits how often fetch and progress events are sent.
+const progressInterval = 250 * time.Millisecond
+
+// Event describes the progress of an ingest.
+type Event struct {
+	Type      string    `json:"type"`
+	Worker    int       `json:"worker"`
+	SourceUID int       `json:"source_uid"`
+	Time      time.Time `json:"time"`
+
+	// Message is a line of fetch output from the git server.
+	Message string `json:"message,omitempty"`
+	// Percent is the completion of the current fetch phase, if known.
+	Percent int `json:"percent,omitempty"`
+
+	Files  int `json:"files,omitempty"`
+	Parsed int `json:"parsed,omitempty"`
+	Failed int `json:"failed,omitempty"`
+	Pruned int `json:"pruned,omitempty"`
+
+	// Result and Error describe the outcome of a finished ingest.
+	Result *Result `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// subscriberBuffer is the number of events buffered for each subscriber.
+// Events are dropped for subscribers which fall further behind.
+const subscriberBuffer = 64
+
+var (
+	subscriberLock sync.Mutex
+	subscribers    = map[chan *Event]bool{}
+)
+
+// Subscribe returns a channel which receives ingest events as they occur,
+// and a function which must be called once events are no longer wanted.
+func Subscribe() (<-chan *Event, func()) {
+	ch := make(chan *Event, subscriberBuffer)
+	subscriberLock.Lock()
+	subscribers[ch] = true
+	subscriberLock.Unlock()
+
+	return ch, func() {
+		subscriberLock.Lock()
+		delete(subscribers, ch)
+		subscriberLock.Unlock()
+	}
+}
+
+// emit sends an event about the ingest of source by the given worker to every
+// subscriber. Private sources are not reported, as they are hidden from the
+// web interface.
+func emit(worker int, source *db.Source, e *Event) {
+	if source.Private {
+		return
+	}
+	e.Worker = worker
+	e.SourceUID = source.UID
+	e.Time = time.Now()
+
+	subscriberLock.Lock()
+	defer subscriberLock.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+var percentRe = regexp.MustCompile(`(\d+)%`)
+
+// fetchProgress publishes the human readable progress output from a git
+// server as fetch events.
+type fetchProgress struct {
+	worker int
+	source *db.Source
+	buf    []byte
+	last   time.Time
+}
+
+func newFetchProgress(worker int, source *db.Source) *fetchProgress {
+	return &fetchProgress{worker: worker, source: source}
+}
+
+func (p *fetchProgress) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		// Servers rewrite the current line with \r as a phase progresses,
+		// and end the line with \n once it is done.
+		done := p.buf[i] == '\n'
+		line := strings.TrimSpace(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+		if line == "" || (!done && time.Since(p.last) < progressInterval) {
+			continue
+		}
+		p.last = time.Now()
+
+		e := &Event{Type: EventFetch, Message: line}
+		if m := percentRe.FindStringSubmatch(line); m != nil {
+			e.Percent, _ = strconv.Atoi(m[1])
+		}
+		emit(p.worker, p.source, e)
+	}
+	return len(b), nil
+}
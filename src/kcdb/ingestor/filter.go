@@ -0,0 +1,81 @@
+package ingestor
+
+import (
+	"kcdb/db"
+	"path"
+	"strings"
+)
+
+// included returns true if the file at p, relative to the root of the source,
+// should be ingested given the source's settings.
+func included(meta *db.SourceMetadata, p string) bool {
+	if sub := strings.Trim(meta.Subdir, "/"); sub != "" && sub != "." {
+		if !strings.HasPrefix(p, sub+"/") {
+			return false
+		}
+		p = p[len(sub)+1:]
+	}
+
+	if len(meta.Include) > 0 {
+		matched := false
+		for _, pattern := range meta.Include {
+			if matchGlob(pattern, p) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, pattern := range meta.Exclude {
+		if matchGlob(pattern, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob returns true if the pattern matches the path or any of its parent
+// directories. Patterns use the syntax of path.Match, with the addition that
+// a '**' segment matches any number of directories. Empty segments, such as
+// from a doubled slash, are ignored.
+func matchGlob(pattern, p string) bool {
+	var pat []string
+	for _, s := range strings.Split(pattern, "/") {
+		if s != "" {
+			pat = append(pat, s)
+		}
+	}
+	if len(pat) == 0 {
+		return false
+	}
+	segs := strings.Split(p, "/")
+	for i := 1; i <= len(segs); i++ {
+		if matchSegments(pat, segs[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,75 @@
+package ingestor
+
+import (
+	"kcdb/db"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tcs := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.kicad_mod", "R.kicad_mod", true},
+		{"*.kicad_mod", "lib/R.kicad_mod", false},
+		{"3d", "3d/R.wrl", true},
+		{"3d", "lib/3d/R.wrl", false},
+
+		// A leading ** matches at any depth, including the root.
+		{"**/*.kicad_mod", "R.kicad_mod", true},
+		{"**/*.kicad_mod", "lib/R.pretty/R.kicad_mod", true},
+		{"**/*.kicad_mod", "lib/R.lib", false},
+		{"**/test", "lib/test/R.kicad_mod", true},
+
+		// A trailing ** matches everything beneath the directory.
+		{"lib/**", "lib/R.kicad_mod", true},
+		{"lib/**", "lib/R.pretty/R.kicad_mod", true},
+		{"lib/**", "libs/R.kicad_mod", false},
+		{"lib/**", "other/lib/R.kicad_mod", false},
+
+		// A ** in the middle matches zero or more directories.
+		{"lib/**/*.kicad_mod", "lib/R.kicad_mod", true},
+		{"lib/**/*.kicad_mod", "lib/a/b/R.kicad_mod", true},
+		{"lib/**/*.kicad_mod", "lib/a/R.lib", false},
+		{"lib/**/*.kicad_mod", "src/lib/R.kicad_mod", false},
+		{"lib/**/old/**", "lib/a/old/b/R.kicad_mod", true},
+
+		// Empty segments are ignored.
+		{"lib//*.kicad_mod", "lib/R.kicad_mod", true},
+		{"/lib/", "lib/R.kicad_mod", true},
+		{"/", "R.kicad_mod", false},
+		{"", "R.kicad_mod", false},
+	}
+	for _, tc := range tcs {
+		if got := matchGlob(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestIncluded(t *testing.T) {
+	tcs := []struct {
+		name string
+		meta db.SourceMetadata
+		path string
+		want bool
+	}{
+		{"no filters", db.SourceMetadata{}, "lib/R.kicad_mod", true},
+		{"subdir", db.SourceMetadata{Subdir: "kicad"}, "kicad/lib/R.kicad_mod", true},
+		{"outside subdir", db.SourceMetadata{Subdir: "kicad"}, "lib/R.kicad_mod", false},
+		{"subdir prefix", db.SourceMetadata{Subdir: "kicad"}, "kicad2/R.kicad_mod", false},
+		{"subdir slashes", db.SourceMetadata{Subdir: "/kicad/"}, "kicad/R.kicad_mod", true},
+		{"include relative to subdir", db.SourceMetadata{Subdir: "kicad", Include: []string{"lib/**"}}, "kicad/lib/R.kicad_mod", true},
+		{"include not relative to subdir", db.SourceMetadata{Subdir: "kicad", Include: []string{"kicad/lib/**"}}, "kicad/lib/R.kicad_mod", false},
+		{"not included", db.SourceMetadata{Include: []string{"lib/**"}}, "other/R.kicad_mod", false},
+		{"excluded", db.SourceMetadata{Subdir: "kicad", Exclude: []string{"**/test/**"}}, "kicad/lib/test/R.kicad_mod", false},
+		{"exclude wins", db.SourceMetadata{Include: []string{"**/*.kicad_mod"}, Exclude: []string{"old"}}, "old/R.kicad_mod", false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := included(&tc.meta, tc.path); got != tc.want {
+				t.Errorf("included(%+v, %q) = %v, want %v", tc.meta, tc.path, got, tc.want)
+			}
+		})
+	}
+}
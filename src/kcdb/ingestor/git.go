@@ -0,0 +1,275 @@
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"kcdb/db"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+// reposDir is where the workers keep the local copy of each source's
+// repository between ingests. A source is only ever claimed by one worker at a
+// time, so the worker has exclusive use of that source's directory.
+const reposDir = "/tmp/kcdb_repos"
+
+// workDir contains a scratch directory for each worker.
+const workDir = "/tmp/kcdb_work"
+
+func setupDirs() error {
+	if err := os.RemoveAll(workDir); err != nil {
+		return err
+	}
+	return os.MkdirAll(reposDir, 0755)
+}
+
+// repoDir returns the directory holding the worker's local copy of the
+// source's repository.
+func (w *worker) repoDir(source *db.Source) string {
+	return filepath.Join(w.repos, strconv.Itoa(source.UID))
+}
+
+// gitSnapshot fetches the latest changes to a git source into the local copy
+// of its repository at dir, returning the files which changed since the last
+// ingested commit. Progress output from the server is written to progress.
+func gitSnapshot(source *db.Source, meta *db.SourceMetadata, dir string, progress io.Writer) (*snapshot, error) {
+	auth, err := gitAuth(source)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := fetchContext()
+	defer cancel()
+	watch := watchSourceSize(dir, cancel)
+	defer watch.Stop()
+	repo, err := syncRepo(ctx, dir, source.URL, meta.Ref, auth, progress)
+	if err != nil {
+		return nil, fetchError(ctx, watch, dir, err)
+	}
+	// Checked before fetching submodules, in case the fetch finished before
+	// the watch noticed it was too large.
+	if err := checkSourceSize(dir); err != nil {
+		return nil, fetchError(ctx, watch, dir, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{root: dir, version: commit.Hash.String(), versionDate: commit.Committer.When}
+	if snap.version == source.LastCommit {
+		fmt.Printf("[ingest][fetch] %s is at %s, which was already ingested.\n", source.URL, snap.version)
+		return snap, nil
+	}
+	fmt.Printf("[ingest][fetch] %s is now at %s.\n", source.URL, snap.version)
+
+	snap.changed, snap.removed, err = changedFiles(repo, source.LastCommit, commit)
+	if err != nil {
+		return nil, err
+	}
+	// Changes to the set of submodules are simplest to handle with a full
+	// ingest, which prunes parts from any submodule which was removed.
+	if contains(snap.changed, ".gitmodules") || contains(snap.removed, ".gitmodules") {
+		snap.changed, snap.removed = nil, nil
+	}
+	if snap.changed == nil {
+		snap.full = true
+		if snap.changed, err = walkFiles(dir, meta.Subdir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Submodules are ingested in full when first seen, or when the commit
+	// they point to changes.
+	changed := snap.changed
+	want := func(p string) bool {
+		return snap.full || contains(changed, p)
+	}
+	subs, subPaths, err := syncSubmodules(ctx, repo, source.URL, "", auth, want, 1)
+	if err != nil {
+		return nil, fetchError(ctx, watch, dir, err)
+	}
+	watch.Stop()
+	if err := checkSourceSize(dir); err != nil {
+		return nil, fetchError(ctx, watch, dir, err)
+	}
+
+	snap.changed = nil
+	for _, p := range changed {
+		if deepestSubmodule(p, subPaths) == "" && !contains(subPaths, p) {
+			snap.changed = append(snap.changed, p)
+		}
+	}
+	for _, sub := range subs {
+		files, err := walkFiles(dir, strings.TrimSuffix(sub.prefix, "/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range files {
+			if deepestSubmodule(p, subPaths)+"/" == sub.prefix {
+				sub.changed = append(sub.changed, p)
+			}
+		}
+	}
+	snap.submodules = subs
+	return snap, nil
+}
+
+// fetchError returns the error to report for a fetch of the repository in dir
+// which failed with err. Fetches cancelled because the source grew too large
+// or took too long are reported as a LimitError, and the local copy of a
+// repository which is too large is removed.
+func fetchError(ctx context.Context, watch *sizeWatch, dir string, err error) error {
+	if werr := watch.Err(); werr != nil {
+		err = werr
+	} else if ctx.Err() == context.DeadlineExceeded {
+		return limitErrorf("fetching took longer than %v", limits.FetchTimeout)
+	}
+	if _, ok := err.(*LimitError); ok {
+		os.RemoveAll(dir)
+	}
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// syncRepo brings the local copy of a repository up to date with its remote,
+// cloning it if no usable local copy exists. The worktree is then reset to the
+// given branch or tag, or the remote's default branch if ref is empty. Only the
+// most recent commits are fetched if shallow clones are enabled. auth may be
+// nil for public repositories, and progress may be nil if progress output
+// from the server is not wanted.
+func syncRepo(ctx context.Context, dir, url, ref string, auth transport.AuthMethod, progress io.Writer) (*git.Repository, error) {
+	var depth int
+	if limits.ShallowClone {
+		depth = 1
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		if err != git.ErrRepositoryNotExists {
+			fmt.Printf("[ingest][fetch] Discarding unusable repository at %q: %v\n", dir, err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+		repo, err = git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+			URL:      url,
+			Depth:    depth,
+			Auth:     auth,
+			Progress: progress,
+		})
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	} else {
+		err = repo.FetchContext(ctx, &git.FetchOptions{Force: true, Tags: git.AllTags, Depth: depth, Auth: auth, Progress: progress})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return nil, err
+		}
+	}
+
+	target, err := resolveRef(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	return repo, wt.Reset(&git.ResetOptions{Commit: target, Mode: git.HardReset})
+}
+
+// resolveRef returns the commit which the remote branch or tag named by ref
+// points to. If ref is empty, the branch which was checked out when the
+// repository was cloned is used.
+func resolveRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if ref == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		ref = head.Name().Short()
+	}
+
+	r, err := repo.Reference(plumbing.ReferenceName("refs/remotes/"+git.DefaultRemoteName+"/"+ref), true)
+	if err == nil {
+		return r.Hash(), nil
+	}
+	r, err = repo.Reference(plumbing.ReferenceName("refs/tags/"+ref), true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not find branch or tag %q: %v", ref, err)
+	}
+	// Annotated tags point to a tag object rather than a commit.
+	if tag, err := repo.TagObject(r.Hash()); err == nil {
+		c, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return c.Hash, nil
+	}
+	return r.Hash(), nil
+}
+
+// changedFiles returns the paths of files which were added or modified, and
+// the paths of files which were removed, between the commit identified by since
+// and the commit to. A nil changed slice is returned if the whole tree needs to
+// be ingested, such as when since is empty or refers to a commit which no
+// longer exists.
+func changedFiles(repo *git.Repository, since string, to *object.Commit) (changed, removed []string, err error) {
+	if since == "" {
+		return nil, nil, nil
+	}
+	from, err := repo.CommitObject(plumbing.NewHash(since))
+	if err != nil {
+		fmt.Printf("[ingest] Could not find previous commit %s, falling back to full ingest: %v\n", since, err)
+		return nil, nil, nil
+	}
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed = []string{}
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch action {
+		case merkletrie.Insert, merkletrie.Modify:
+			changed = append(changed, c.To.Name)
+		case merkletrie.Delete:
+			removed = append(removed, c.From.Name)
+		}
+	}
+	return changed, removed, nil
+}
@@ -3,62 +3,276 @@ package ingestor
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"kcdb/db"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 )
 
 var lock sync.Mutex
-var nextIngest time.Time
 var ingestDelaySeconds int
-var current *db.Source
 
-// Start begins the ingestion routine.
-func Start(delaySecs int) error {
+// maxFailures is the number of consecutive failed ingests after which a
+// source is disabled. Zero means sources are never disabled.
+var maxFailures = 10
+
+// maxBackoff bounds the delay before a failing source is retried.
+const maxBackoff = 24 * time.Hour
+
+var workers []*worker
+var lastResult *Result
+
+// queue lists the UIDs of sources which should be ingested as soon as a
+// worker is free, ahead of the regular schedule.
+var queue []int
+
+// worker ingests sources one at a time. Fields are protected by lock.
+type worker struct {
+	id int
+	// dir is a scratch directory for the exclusive use of the worker.
+	dir string
+	// repos is the directory holding local copies of repositories.
+	repos      string
+	current    *db.Source
+	nextIngest time.Time
+	lastResult *Result
+}
+
+// WorkerState describes what an ingest worker is doing.
+type WorkerState struct {
+	ID         int        `json:"id"`
+	Current    *db.Source `json:"current"`
+	NextIngest time.Time  `json:"next_ingest"`
+	LastResult *Result    `json:"last_result"`
+}
+
+// Start begins the ingestion routine, using numWorkers workers which each
+// wait delaySecs between ingests. Sources are disabled after failing
+// failureLimit times in a row, unless failureLimit is zero.
+func Start(delaySecs, numWorkers, failureLimit int) error {
+	if numWorkers < 1 {
+		return fmt.Errorf("at least one ingest worker is required, got %d", numWorkers)
+	}
 	ingestDelaySeconds = delaySecs
-	nextIngest = time.Now().Add(time.Duration(ingestDelaySeconds) * time.Second / 2)
+	maxFailures = failureLimit
 
-	if err := clearDir(); err != nil {
+	if err := setupDirs(); err != nil {
 		return err
 	}
 
-	go ingestRoutine()
+	delay := time.Duration(ingestDelaySeconds) * time.Second
+	for i := 0; i < numWorkers; i++ {
+		w := &worker{
+			id:         i,
+			dir:        filepath.Join(workDir, strconv.Itoa(i)),
+			repos:      reposDir,
+			nextIngest: time.Now().Add(delay/2 + delay*time.Duration(i)/time.Duration(numWorkers)),
+		}
+		workers = append(workers, w)
+		go w.ingestRoutine()
+	}
 	return nil
 }
 
-// ComputeIngestTargets returns the source which should next be ingested.
+func inFlight(uid int) bool {
+	for _, w := range workers {
+		if w.current != nil && w.current.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func computeIngestTargets() ([]*db.Source, error) {
+	sources, err := db.SourcesLastUpdated(context.Background(), 5+len(workers), db.DB())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*db.Source, 0, len(sources))
+	for _, s := range sources {
+		if !inFlight(s.UID) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// ComputeIngestTargets returns the sources which should next be ingested.
 func ComputeIngestTargets() ([]*db.Source, error) {
 	lock.Lock()
 	defer lock.Unlock()
+	return computeIngestTargets()
+}
 
-	sources, err := db.SourcesLastUpdated(context.Background(), 5, db.DB())
-	if err != nil {
-		return nil, err
+// Enqueue requests that the source with the given UID be ingested as soon as
+// a worker is free.
+func Enqueue(uid int) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, q := range queue {
+		if q == uid {
+			return
+		}
+	}
+	queue = append(queue, uid)
+}
+
+// Queue returns the UIDs of sources waiting to be ingested ahead of schedule.
+func Queue() []int {
+	lock.Lock()
+	defer lock.Unlock()
+	return append([]int(nil), queue...)
+}
+
+// claim assigns the next source to be ingested to the worker, returning nil
+// if there is nothing to ingest. Queued sources are claimed first, otherwise
+// a source is only claimed if the worker is due to ingest, which is indicated
+// by the returned bool.
+func (w *worker) claim() (*db.Source, bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i, uid := range queue {
+		if inFlight(uid) {
+			continue
+		}
+		queue = append(queue[:i:i], queue[i+1:]...)
+		s, err := db.GetSource(context.Background(), uid, db.DB())
+		if err != nil {
+			return nil, false, fmt.Errorf("loading queued source %d: %v", uid, err)
+		}
+		w.current = s
+		return s, false, nil
 	}
-	if len(sources) > 1 && current != nil && sources[0].UID == current.UID {
-		sources = sources[1:]
+
+	if w.nextIngest.After(time.Now()) {
+		return nil, false, nil
+	}
+	targets, err := computeIngestTargets()
+	if err != nil || len(targets) == 0 {
+		return nil, true, err
+	}
+	w.current = targets[0]
+	return w.current, true, nil
+}
+
+// State returns the internal state of the ingestor: the sources currently being
+// ingested, the state of each worker, the delay between ingests, and the time
+// at which the next ingest is scheduled to begin.
+func State() ([]*db.Source, []WorkerState, int, time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var (
+		current []*db.Source
+		states  []WorkerState
+		next    time.Time
+	)
+	for _, w := range workers {
+		if w.current != nil {
+			current = append(current, w.current)
+		} else if next.IsZero() || w.nextIngest.Before(next) {
+			next = w.nextIngest
+		}
+		states = append(states, WorkerState{
+			ID:         w.id,
+			Current:    w.current,
+			NextIngest: w.nextIngest,
+			LastResult: w.lastResult,
+		})
 	}
-	return sources, nil
+	return current, states, ingestDelaySeconds, next
 }
 
-// State returns the internal state of the ingestor.
-func State() (*db.Source, int, time.Time) {
+// LastResult returns a summary of the most recently completed ingest.
+func LastResult() *Result {
 	lock.Lock()
 	defer lock.Unlock()
-	return current, ingestDelaySeconds, nextIngest
+	return lastResult
+}
+
+func (w *worker) setLastResult(r *Result) {
+	lock.Lock()
+	defer lock.Unlock()
+	w.lastResult = r
+	lastResult = r
+}
+
+// backoff returns how long to wait before retrying a source which has failed
+// the given number of times in a row.
+func backoff(failures int) time.Duration {
+	d := time.Duration(ingestDelaySeconds) * time.Second
+	if d < time.Minute {
+		d = time.Minute
+	}
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
 }
 
-func ingestRoutine() {
+// recordFailure records a failed ingest against the source, scheduling a
+// retry or disabling it.
+func recordFailure(source *db.Source, ingestErr error) error {
+	failures := source.FailureCount + 1
+	disable := maxFailures > 0 && failures >= maxFailures
+	next := time.Now().Add(backoff(failures))
+	if disable {
+		fmt.Printf("[ingest] Disabling %s after %d consecutive failures.\n", source.URL, failures)
+	} else {
+		fmt.Printf("[ingest] %s has failed %d times, retrying after %s.\n", source.URL, failures, next.Format(time.RFC3339))
+	}
+	return db.SetSourceFailed(context.Background(), source.UID, ingestErr.Error(), next, disable, db.DB())
+}
+
+func (w *worker) ingestRoutine() {
 	for {
 		time.Sleep(time.Second)
-		if nextIngest.Before(time.Now()) {
-			if err := doIngest(); err != nil {
-				fmt.Printf("Ingest failed: %v\n", err)
+		current, due, err := w.claim()
+		if err != nil {
+			fmt.Printf("[ingest][%d] Ingest failed: %v\n", w.id, err)
+		} else if current != nil {
+			r, err := w.doIngest(current)
+			if err != nil {
+				fmt.Printf("[ingest][%d] Ingest failed: %v\n", w.id, err)
+			} else if r != nil {
+				w.setLastResult(r)
 			}
-			lock.Lock()
-			nextIngest = time.Now().Add(time.Duration(ingestDelaySeconds) * time.Second)
-			current = nil
-			lock.Unlock()
 		}
+
+		lock.Lock()
+		if due {
+			w.nextIngest = time.Now().Add(time.Duration(ingestDelaySeconds) * time.Second)
+		}
+		w.current = nil
+		lock.Unlock()
+	}
+}
+
+// Ingest synchronously ingests a source outside of the worker pool, returning
+// a summary of the changes made. A nil result is returned if the source was
+// already up to date. The repository is cloned afresh into a temporary
+// directory, as a running server may be using its local copy.
+func Ingest(source *db.Source) (*Result, error) {
+	dir, err := ioutil.TempDir("", "kcdb_ingest")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	w := &worker{
+		dir:     filepath.Join(dir, "work"),
+		repos:   filepath.Join(dir, "repos"),
+		current: source,
+	}
+	if err := os.MkdirAll(w.repos, 0755); err != nil {
+		return nil, err
 	}
+	return w.doIngest(source)
 }
@@ -0,0 +1,63 @@
+package ingestor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"kcdb/db"
+	"path/filepath"
+	"strings"
+
+	"github.com/twitchyliquid64/kcgen/pcb"
+)
+
+// legacyModHeader starts every legacy footprint library. Other files with a
+// .mod extension, such as go.mod, are skipped.
+var legacyModHeader = []byte("PCBNEW-LibModule-V1")
+
+// ingestLegacyLibrary stores the footprints in the legacy (KiCad 4 and
+// earlier) footprint library at path. Each footprint is named after the
+// library file and its name in the library, and is stored converted to the
+// .kicad_mod format.
+func (p *ingestPass) ingestLegacyLibrary(o *origin, path string) error {
+	b, err := ioutil.ReadFile(filepath.Join(p.root, path))
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(b), legacyModHeader) {
+		return nil
+	}
+	url := db.MakePartURL(o.url, strings.TrimPrefix(path, o.prefix))
+	p.scope = append(p.scope, url)
+	p.paths = append(p.paths, path)
+
+	var mods []*pcb.Module
+	err = withParseTimeout(p.source.UID, path, func() (err error) {
+		mods, err = pcb.ParseLegacyLibrary(bytes.NewReader(b))
+		return err
+	})
+	if abortsIngest(err) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(p.log, "[ingest][footprint] Failed parsing %q: %v\n", path, err)
+		p.fail(o, path, url, err)
+		return nil
+	}
+
+	for _, m := range mods {
+		var buf bytes.Buffer
+		if err := m.WriteModule(&buf); err != nil {
+			return err
+		}
+		hash, err := canonicalHash(m)
+		if err != nil {
+			return err
+		}
+		p.seen[url+"::"+m.Name] = true
+		if err := p.sink.footprint(o, url+"::"+m.Name, buf.Bytes(), m, hash, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package ingestor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"kcdb/db"
+	"kcdb/libtable"
+	"path/filepath"
+	"strings"
+)
+
+// ingestLibTable records the git repositories referenced by the library table
+// at path which are not yet sources, so they can be reviewed by an admin.
+func (p *ingestPass) ingestLibTable(o *origin, path string) error {
+	b, err := ioutil.ReadFile(filepath.Join(p.root, path))
+	if err != nil {
+		return err
+	}
+	url := db.MakePartURL(o.url, strings.TrimPrefix(path, o.prefix))
+	p.paths = append(p.paths, path)
+
+	if err := checkParseDepth(b); err != nil {
+		return err
+	}
+	var table *libtable.Table
+	err = withParseTimeout(p.source.UID, path, func() (err error) {
+		table, err = libtable.Decode(strings.NewReader(string(b)))
+		return err
+	})
+	if abortsIngest(err) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(p.log, "[ingest][libtable] Failed parsing %q: %v\n", path, err)
+		p.fail(o, path, url, err)
+		return nil
+	}
+
+	counts := map[string]int{}
+	var repos []string
+	for _, lib := range table.Libs {
+		if repo, ok := libtable.RepoURL(lib.URI); ok {
+			if counts[repo] == 0 {
+				repos = append(repos, repo)
+			}
+			counts[repo]++
+		}
+	}
+	for _, repo := range repos {
+		registered, err := p.sink.registered(repo)
+		if err != nil {
+			return err
+		}
+		if registered {
+			continue
+		}
+		p.candidates = append(p.candidates, &db.SourceCandidate{URL: repo, Path: path, Count: counts[repo]})
+	}
+	return nil
+}
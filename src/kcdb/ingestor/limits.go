@@ -0,0 +1,332 @@
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Limits bounds the resources which may be used ingesting a single source.
+// A zero value for any field disables that limit.
+type Limits struct {
+	// ShallowClone fetches only the most recent commit of git sources.
+	ShallowClone bool
+	// FetchTimeout bounds the time taken to clone or fetch a source.
+	FetchTimeout time.Duration
+	// MaxSourceSize bounds the size of a source on disk in bytes, including
+	// repository metadata or the downloaded archive.
+	MaxSourceSize int64
+	// MaxFiles bounds the number of files in a source which are considered.
+	MaxFiles int
+	// MaxFileSize bounds the size of a file which is parsed, in bytes.
+	MaxFileSize int64
+	// MaxParseDepth bounds the nesting of s-expressions in a footprint, and
+	// ParseTimeout bounds the time taken to parse a single file.
+	MaxParseDepth int
+	ParseTimeout  time.Duration
+	// MaxTimedOutParses bounds the number of parses from one source which
+	// timed out but are still running in the background. Ingests of every
+	// source are postponed while four times as many are running in total.
+	MaxTimedOutParses int
+}
+
+// DefaultLimits are the limits applied unless SetLimits is called.
+var DefaultLimits = Limits{
+	ShallowClone:  true,
+	FetchTimeout:  10 * time.Minute,
+	MaxSourceSize: 2 << 30,
+	MaxFiles:      200000,
+	MaxFileSize:   16 << 20,
+	MaxParseDepth: 64,
+	ParseTimeout:  30 * time.Second,
+
+	MaxTimedOutParses: 4,
+}
+
+var limits = DefaultLimits
+
+// SetLimits sets the resource limits for ingesting sources. It must be called
+// before Start or Ingest.
+func SetLimits(l Limits) {
+	limits = l
+}
+
+// LimitError is returned when ingesting a source is aborted because it
+// exceeded a resource limit.
+type LimitError struct {
+	msg string
+}
+
+func (e *LimitError) Error() string {
+	return "limit exceeded: " + e.msg
+}
+
+func limitErrorf(format string, args ...interface{}) error {
+	return &LimitError{msg: fmt.Sprintf(format, args...)}
+}
+
+// BusyError is returned when ingesting a source is postponed because the
+// ingestor is short of resources through no fault of the source. It is not
+// counted as a failure of the source, which is retried when next due.
+type BusyError struct {
+	msg string
+}
+
+func (e *BusyError) Error() string {
+	return "ingestor busy: " + e.msg
+}
+
+// abortsIngest returns true if err from parsing a file should abort the
+// ingest of the whole source, rather than be recorded against the file.
+func abortsIngest(err error) bool {
+	switch err.(type) {
+	case *LimitError, *BusyError:
+		return true
+	}
+	return false
+}
+
+// fetchContext returns a context bounded by the fetch timeout.
+func fetchContext() (context.Context, context.CancelFunc) {
+	if limits.FetchTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), limits.FetchTimeout)
+}
+
+// checkSourceSize returns an error if the total size of the files in dir
+// exceeds the maximum source size.
+func checkSourceSize(dir string) error {
+	if limits.MaxSourceSize <= 0 {
+		return nil
+	}
+	var total int64
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		if total > limits.MaxSourceSize {
+			return limitErrorf("source is larger than %d bytes", limits.MaxSourceSize)
+		}
+		return nil
+	})
+}
+
+// sizeCheckInterval is how often the size of a source is checked while it is
+// being fetched.
+const sizeCheckInterval = 5 * time.Second
+
+// sizeWatch checks the size of a source's directory while a fetch writes to
+// it, cancelling the fetch once the source exceeds the maximum source size.
+type sizeWatch struct {
+	stop chan struct{}
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// watchSourceSize starts checking the size of dir, calling cancel if it grows
+// too large. Stop must be called once the fetch has finished.
+func watchSourceSize(dir string, cancel context.CancelFunc) *sizeWatch {
+	w := &sizeWatch{stop: make(chan struct{}), done: make(chan struct{})}
+	if limits.MaxSourceSize <= 0 {
+		close(w.done)
+		return w
+	}
+	go func() {
+		defer close(w.done)
+		t := time.NewTicker(sizeCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-t.C:
+			}
+			// Files may be renamed or removed while the fetch runs, so only
+			// exceeding the limit is treated as an error.
+			if err, ok := checkSourceSize(dir).(*LimitError); ok {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+				cancel()
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Stop stops checking the size of the source. It is safe to call more than
+// once.
+func (w *sizeWatch) Stop() {
+	select {
+	case <-w.done:
+	default:
+		close(w.stop)
+		<-w.done
+	}
+}
+
+// Err returns the LimitError which caused the fetch to be cancelled, or nil
+// if the source has not exceeded the maximum size.
+func (w *sizeWatch) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// sizeBudget tracks the number of bytes which may still be written for a
+// source. A nil budget is unlimited.
+type sizeBudget struct {
+	remaining int64
+}
+
+func newSizeBudget() *sizeBudget {
+	if limits.MaxSourceSize <= 0 {
+		return nil
+	}
+	return &sizeBudget{remaining: limits.MaxSourceSize}
+}
+
+// copy copies from src to dst, returning an error if the budget is exhausted.
+func (b *sizeBudget) copy(dst io.Writer, src io.Reader) error {
+	if b == nil {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, b.remaining+1))
+	b.remaining -= n
+	if err != nil {
+		return err
+	}
+	if b.remaining < 0 {
+		return limitErrorf("source is larger than %d bytes", limits.MaxSourceSize)
+	}
+	return nil
+}
+
+// checkFileSize returns an error if the file at path is too large to parse.
+func checkFileSize(path string) error {
+	if limits.MaxFileSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > limits.MaxFileSize {
+		return limitErrorf("%s is larger than %d bytes", filepath.Base(path), limits.MaxFileSize)
+	}
+	return nil
+}
+
+// checkParseDepth returns an error if s-expressions in b are nested deeper
+// than the maximum parse depth.
+func checkParseDepth(b []byte) error {
+	if limits.MaxParseDepth <= 0 {
+		return nil
+	}
+	var (
+		depth            int
+		inString, escape bool
+	)
+	for _, c := range b {
+		switch {
+		case escape:
+			escape = false
+		case inString && c == '\\':
+			escape = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(':
+			if depth++; depth > limits.MaxParseDepth {
+				return limitErrorf("s-expressions are nested deeper than %d", limits.MaxParseDepth)
+			}
+		case c == ')':
+			depth--
+		}
+	}
+	return nil
+}
+
+// timedOutSourceFactor is how many sources' worth of timed out parses may be
+// running before every ingest is postponed.
+const timedOutSourceFactor = 4
+
+var (
+	timedOutLock sync.Mutex
+	// timedOutParses counts the parses which timed out but have not yet
+	// finished, by the UID of the source they are from.
+	timedOutParses = map[int]int{}
+	timedOutTotal  int
+)
+
+// withParseTimeout runs parse, giving up if it does not complete within the
+// parse timeout. The parsers cannot be interrupted, so a parse which times
+// out is left to finish in the background, holding on to its goroutine and
+// memory until it does. Once the maximum number of such parses from a source
+// are running, its further parses fail immediately rather than risk leaking
+// more. If too many are running in total, other sources are postponed.
+func withParseTimeout(sourceUID int, path string, parse func() error) error {
+	if limits.ParseTimeout <= 0 {
+		return recoverParse(path, parse)
+	}
+	if max := limits.MaxTimedOutParses; max > 0 {
+		timedOutLock.Lock()
+		own, total := timedOutParses[sourceUID], timedOutTotal
+		timedOutLock.Unlock()
+		if own >= max {
+			return limitErrorf("%d parses from this source which timed out are still running", own)
+		}
+		if total >= max*timedOutSourceFactor {
+			return &BusyError{msg: fmt.Sprintf("%d parses which timed out are still running", total)}
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- recoverParse(path, parse)
+	}()
+	timer := time.NewTimer(limits.ParseTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		addTimedOut(sourceUID, 1)
+		go func() {
+			<-done
+			addTimedOut(sourceUID, -1)
+		}()
+		return limitErrorf("parsing %s took longer than %v", path, limits.ParseTimeout)
+	}
+}
+
+func addTimedOut(sourceUID, n int) {
+	timedOutLock.Lock()
+	defer timedOutLock.Unlock()
+	timedOutParses[sourceUID] += n
+	timedOutTotal += n
+	if timedOutParses[sourceUID] == 0 {
+		delete(timedOutParses, sourceUID)
+	}
+}
+
+// recoverParse runs parse, returning any panic as an error. The parsers panic
+// on some malformed input, which is recorded as a failure of that file rather
+// than taking down the server.
+func recoverParse(path string, parse func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parsing %s: %v", path, r)
+		}
+	}()
+	return parse()
+}
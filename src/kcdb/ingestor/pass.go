@@ -0,0 +1,195 @@
+package ingestor
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"kcdb/db"
+	"os"
+	"strings"
+	"time"
+)
+
+// ingestPass tracks the state of a single ingestion pass over a source.
+type ingestPass struct {
+	source *db.Source
+	root   string
+	// sink receives the parts which are found.
+	sink sink
+	// log receives messages about files which could not be ingested.
+	log io.Writer
+
+	// full is set if every file in the source was ingested, in which case
+	// any part which was not seen is pruned.
+	full bool
+	// scope lists the URLs of files which were ingested or removed. Parts
+	// beneath these files which were not seen are pruned.
+	scope []string
+	// failed lists the URLs of files which could not be parsed. Parts beneath
+	// these files are kept as-is.
+	failed []string
+	seen   map[string]bool
+	// boards lists the board files which are waiting to be ingested.
+	boards []boardFile
+
+	// paths lists the files which were ingested or removed, and errs
+	// describes those which could not be parsed.
+	paths []string
+	errs  []*db.IngestError
+	// candidates lists repositories referenced by the library tables which
+	// were ingested.
+	candidates []*db.SourceCandidate
+
+	pruned int
+}
+
+func newIngestPass(source *db.Source, root string) *ingestPass {
+	return &ingestPass{
+		source: source,
+		root:   root,
+		sink:   &dbSink{source: source},
+		log:    os.Stdout,
+		seen:   map[string]bool{},
+	}
+}
+
+// underFile returns true if the part URL refers to the file with the given URL,
+// or to a part within it.
+func underFile(url, fileURL string) bool {
+	return url == fileURL || strings.HasPrefix(url, fileURL+"::")
+}
+
+func (p *ingestPass) shouldPrune(url string) bool {
+	if p.seen[url] {
+		return false
+	}
+	for _, f := range p.failed {
+		if underFile(url, f) {
+			return false
+		}
+	}
+	if p.full {
+		return true
+	}
+	for _, f := range p.scope {
+		if underFile(url, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes parts from the source which were in scope but not seen.
+func (p *ingestPass) prune() error {
+	ctx := context.Background()
+	// Parts from a submodule may also be provided by other sources which
+	// embed the same submodule. This source stops providing them, and they
+	// are only deleted if no other source provides them.
+	provided, err := db.PartProviderURLs(ctx, p.source.UID, db.DB())
+	if err != nil {
+		return err
+	}
+	var gone []string
+	for _, url := range provided {
+		if p.shouldPrune(url) {
+			gone = append(gone, url)
+		}
+	}
+	if err := db.RemovePartProviders(ctx, p.source.UID, gone, db.DB()); err != nil {
+		return err
+	}
+
+	fps, err := db.FootprintURLsBySource(ctx, p.source.UID, db.DB())
+	if err != nil {
+		return err
+	}
+	del, err := p.prunable(ctx, fps, db.SetFootprintSource)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteFootprints(ctx, del, db.DB()); err != nil {
+		return err
+	}
+	p.pruned += len(del)
+
+	syms, err := db.SymbolURLsBySource(ctx, p.source.UID, db.DB())
+	if err != nil {
+		return err
+	}
+	if del, err = p.prunable(ctx, syms, db.SetSymbolSource); err != nil {
+		return err
+	}
+	if err := db.DeleteSymbols(ctx, del, db.DB()); err != nil {
+		return err
+	}
+	p.pruned += len(del)
+	return nil
+}
+
+// prunable returns the UIDs of the parts, keyed by URL, which should be
+// deleted. Parts which another source still provides are attributed to that
+// source with setSource instead.
+func (p *ingestPass) prunable(ctx context.Context, parts map[string]int, setSource func(context.Context, int, int, *sql.DB) error) ([]int, error) {
+	var del []int
+	for url, uid := range parts {
+		if !p.shouldPrune(url) {
+			continue
+		}
+		other, err := db.PartProvider(ctx, url, db.DB())
+		if err != nil {
+			return nil, err
+		}
+		if other != 0 {
+			if err := setSource(ctx, uid, other, db.DB()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		del = append(del, uid)
+	}
+	return del, nil
+}
+
+// fail records that the file at path could not be parsed.
+func (p *ingestPass) fail(o *origin, path, url string, err error) {
+	p.failed = append(p.failed, url)
+	p.errs = append(p.errs, &db.IngestError{
+		SourceID: p.source.UID,
+		Path:     path,
+		Message:  err.Error(),
+		Commit:   o.commit,
+	})
+}
+
+// saveErrors records the files which could not be parsed, clearing any
+// errors for files which have since been ingested or removed.
+func (p *ingestPass) saveErrors() error {
+	return db.UpdateIngestErrors(context.Background(), p.source.UID, p.full, p.paths, p.errs, db.DB())
+}
+
+// saveCandidates records the repositories referenced by library tables,
+// clearing those from tables which have since been ingested or removed.
+func (p *ingestPass) saveCandidates() error {
+	return db.UpdateSourceCandidates(context.Background(), p.source.UID, p.full, p.paths, p.candidates, db.DB())
+}
+
+// Result summarizes a completed ingestion pass.
+type Result struct {
+	SourceUID int       `json:"source_uid"`
+	Full      bool      `json:"full"`
+	Parts     int       `json:"parts"`
+	Pruned    int       `json:"pruned"`
+	Failed    int       `json:"failed"`
+	Finished  time.Time `json:"finished"`
+}
+
+func (p *ingestPass) result() *Result {
+	return &Result{
+		SourceUID: p.source.UID,
+		Full:      p.full,
+		Parts:     len(p.seen),
+		Pruned:    p.pruned,
+		Failed:    len(p.failed),
+		Finished:  time.Now(),
+	}
+}
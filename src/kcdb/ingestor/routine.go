@@ -6,186 +6,379 @@ import (
 	"fmt"
 	"io/ioutil"
 	"kcdb/db"
+	"kcdb/libtable"
 	"kcdb/sym"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/twitchyliquid64/kcgen/pcb"
-	git "gopkg.in/src-d/go-git.v4"
 )
 
-const tmpDir = "/tmp/kcdb_repo"
+// snapshot describes the files of a source which need to be ingested.
+type snapshot struct {
+	root string
+	// version identifies the content of the source, such as a commit hash.
+	// It is recorded as the last commit of the source once ingested.
+	version string
+	// versionDate is the time at which version was created, if known.
+	versionDate time.Time
+	// full is set if changed lists every file in the source.
+	full bool
+	// submodules lists the submodules of the source which need to be
+	// ingested, along with their files.
+	submodules []*submodule
+	// changed lists the paths of files which need to be ingested, and
+	// removed lists the paths of files which no longer exist. Paths are
+	// relative to root.
+	changed []string
+	removed []string
+	// done, if set, is called once the snapshot has been ingested.
+	done func() error
+}
 
-func clearDir() error {
-	if err := os.RemoveAll(tmpDir); err != nil && os.IsNotExist(err) {
-		return err
+// doIngest ingests the source, returning a summary of the changes made, or
+// nil if it was already up to date. The outcome is recorded against the source.
+func (w *worker) doIngest(current *db.Source) (r *Result, err error) {
+	emit(w.id, current, &Event{Type: EventStart})
+	defer func() {
+		fmt.Printf("[ingest][%d] Starting Vacuum.\n", w.id)
+		emit(w.id, current, &Event{Type: EventVacuum})
+		db.Vacuum(db.DB())
+		fmt.Printf("[ingest][%d] Finished routine.\n", w.id)
+
+		e := &Event{Type: EventFinish, Result: r}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		emit(w.id, current, e)
+	}()
+
+	fmt.Printf("[ingest][%d] Updating: %v (%d)\n", w.id, current.URL, current.UID)
+	r, err = w.ingest(current)
+	if _, ok := err.(*BusyError); ok {
+		fmt.Printf("[ingest][%d] Postponing %s: %v\n", w.id, current.URL, err)
+		return nil, err
+	}
+	if err != nil {
+		if recErr := recordFailure(current, err); recErr != nil {
+			fmt.Printf("[ingest][%d] Failed to record failure: %v\n", w.id, recErr)
+		}
+		return nil, err
 	}
-	return os.Mkdir(tmpDir, 0755)
+	return r, db.SetSourceUpdated(context.Background(), current.UID, db.DB())
 }
 
-func doIngest() error {
-	targets, err := ComputeIngestTargets()
+func (w *worker) ingest(current *db.Source) (*Result, error) {
+	meta, err := current.ParseMetadata()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("bad source metadata: %v", err)
 	}
-	if len(targets) == 0 {
-		return nil
+
+	var snap *snapshot
+	switch current.Kind {
+	case db.SourceKindGit:
+		snap, err = gitSnapshot(current, meta, w.repoDir(current), newFetchProgress(w.id, current))
+	case db.SourceKindDir:
+		snap, err = dirSnapshot(current, meta)
+	case db.SourceKindArchive:
+		snap, err = archiveSnapshot(current, meta, w.dir)
+	default:
+		err = fmt.Errorf("unknown source kind %q", current.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap.changed = withDocumentedLibraries(snap.root, snap.changed, snap.removed)
+	numFiles := len(snap.changed) + len(snap.removed)
+	for _, sub := range snap.submodules {
+		numFiles += len(sub.changed)
+	}
+	if limits.MaxFiles > 0 && numFiles > limits.MaxFiles {
+		return nil, limitErrorf("source has more than %d files", limits.MaxFiles)
+	}
+	if !snap.full && numFiles == 0 {
+		fmt.Printf("[ingest][%d] Already up to date.\n", w.id)
+		return nil, nil
 	}
-	lock.Lock()
-	current = targets[0]
-	lock.Unlock()
 
-	if err = clearDir(); err != nil {
-		return err
+	pass := newIngestPass(current, snap.root)
+	pass.full = snap.full
+	root := &origin{url: current.URL, commit: snap.version, date: snap.versionDate}
+	if snap.full {
+		fmt.Printf("[ingest][%d] Full ingest of %d files.\n", w.id, len(snap.changed))
+	} else {
+		fmt.Printf("[ingest][%d] %d files changed and %d removed.\n", w.id, len(snap.changed), len(snap.removed))
 	}
-	defer func() {
-		fmt.Printf("[ingest] Starting Vacuum.\n")
-		db.Vacuum(db.DB())
-		fmt.Printf("[ingest] Finished routine.\n")
-	}()
 
-	fmt.Printf("[ingest][clone] Cloning: %v (%d)\n", current.URL, current.UID)
-	defer db.SetSourceUpdated(context.Background(), current.UID, db.DB())
-	_, err = git.PlainClone(tmpDir, false, &git.CloneOptions{
-		URL: current.URL,
-	})
-	if err != nil {
-		return err
+	toParse := numFiles - len(snap.removed)
+	emit(w.id, current, &Event{Type: EventFiles, Files: toParse})
+	var (
+		parsed       int
+		lastProgress time.Time
+	)
+	progress := func(force bool) {
+		if !force && time.Since(lastProgress) < progressInterval {
+			return
+		}
+		lastProgress = time.Now()
+		emit(w.id, current, &Event{Type: EventProgress, Files: toParse, Parsed: parsed, Failed: len(pass.failed)})
 	}
-	fmt.Printf("[ingest][clone] Finished.\n")
 
-	err = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Printf("[ingest][walk] Could not read %q: %v\n", path, err)
-			return err
+	for _, p := range snap.changed {
+		parsed++
+		progress(false)
+		if !included(meta, p) {
+			continue
 		}
-		if strings.HasSuffix(path, ".kicad_mod") && !strings.Contains(path, "/.git/") {
-			b, err := ioutil.ReadFile(path)
-			if err != nil {
-				return err
+		if err := pass.ingestFile(root, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, sub := range snap.submodules {
+		fmt.Printf("[ingest][%d] Ingesting %d files from submodule %s.\n", w.id, len(sub.changed), sub.url)
+		// Every part from the submodule is in scope, so parts from files
+		// which no longer exist are pruned.
+		pass.scope = append(pass.scope, strings.TrimSuffix(db.MakePartURL(sub.url, ""), "::"))
+		for _, p := range sub.changed {
+			parsed++
+			progress(false)
+			if !included(meta, p) {
+				continue
 			}
-			url := db.MakePartURL(current.URL, path[len(tmpDir)+1:])
-
-			// fmt.Printf("File: %+v\n", path)
-			mod, err := pcb.ParseModule(strings.NewReader(string(b)))
-			if err != nil {
-				fmt.Printf("[ingest][footprint] Failed parsing %q: %v\n", path, err)
-				fmt.Println(string(b))
-				return nil
+			if err := pass.ingestFile(&sub.origin, p); err != nil {
+				return nil, err
 			}
+		}
+	}
+	// Boards are ingested last so their footprints can be compared against
+	// every library footprint from the source.
+	if err := pass.ingestBoards(); err != nil {
+		return nil, err
+	}
+	progress(true)
+	for _, p := range snap.removed {
+		pass.scope = append(pass.scope, db.MakePartURL(current.URL, p))
+		pass.paths = append(pass.paths, p)
+	}
+	if err := pass.prune(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("[ingest][%d] Pruned %d parts.\n", w.id, pass.pruned)
+	emit(w.id, current, &Event{Type: EventPruned, Pruned: pass.pruned})
+	if err := pass.saveErrors(); err != nil {
+		return nil, err
+	}
+	if err := pass.saveCandidates(); err != nil {
+		return nil, err
+	}
+	if len(pass.candidates) > 0 {
+		fmt.Printf("[ingest][%d] Library tables reference %d repositories which are not sources.\n", w.id, len(pass.candidates))
+	}
+	if len(pass.failed) > 0 {
+		fmt.Printf("[ingest][%d] %d files could not be parsed.\n", w.id, len(pass.failed))
+	}
 
-			_, err = upsertFootprint(current, url, b, mod)
-			if err != nil {
-				return err
-			}
+	if snap.done != nil {
+		if err := snap.done(); err != nil {
+			return nil, err
+		}
+	}
+	if snap.version != "" {
+		if err := db.SetSourceCommit(context.Background(), current.UID, snap.version, db.DB()); err != nil {
+			return nil, err
+		}
+	}
+	return pass.result(), nil
+}
 
-			//fmt.Printf("[ingest][footprint] Successfully parsed %s\n", footprint.Name)
-		} else if strings.HasSuffix(path, ".lib") {
-			b, err := ioutil.ReadFile(path)
-			if err != nil {
-				return err
+// walkFiles returns the paths of all files beneath the subdirectory of root,
+// relative to root.
+func walkFiles(root, subdir string) ([]string, error) {
+	var out []string
+	err := filepath.Walk(filepath.Join(root, filepath.FromSlash(subdir)), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
 			}
-			url := db.MakePartURL(current.URL, path[len(tmpDir)+1:])
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		out = append(out, filepath.ToSlash(rel))
+		return nil
+	})
+	return out, err
+}
 
-			symbols, err := sym.DecodeSymbolLibrary(bytes.NewBuffer(b))
-			if err != nil {
-				fmt.Printf("[ingest][symbols] Failed parsing %q: %v\n", path, err)
-				// fmt.Println(string(b))
-				return nil
-			}
+// ingestable returns true if the file at path may contain parts.
+func ingestable(path string) bool {
+	return strings.HasSuffix(path, ".kicad_mod") || strings.HasSuffix(path, ".mod") || strings.HasSuffix(path, ".lib") || strings.HasSuffix(path, ".dcm") || strings.HasSuffix(path, ".kicad_sym") || strings.HasSuffix(path, ".kicad_pcb") || libtable.IsTableFile(path)
+}
 
-			for i := range symbols {
-				_, err = upsertSymbol(current, url+"::"+symbols[i].Name, []byte(symbols[i].RawData), symbols[i])
-				if err != nil {
-					return err
-				}
+// withDocumentedLibraries returns changed along with the legacy symbol
+// libraries whose .dcm documentation file was changed or removed, as the
+// documentation is ingested along with the library.
+func withDocumentedLibraries(root string, changed, removed []string) []string {
+	pending := map[string]bool{}
+	for _, p := range changed {
+		pending[p] = true
+	}
+	out := changed
+	for _, list := range [][]string{changed, removed} {
+		for _, p := range list {
+			if !strings.HasSuffix(p, ".dcm") {
+				continue
 			}
+			lib := strings.TrimSuffix(p, ".dcm") + ".lib"
+			if pending[lib] {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(lib))); err != nil {
+				continue
+			}
+			pending[lib] = true
+			out = append(out, lib)
 		}
+	}
+	return out
+}
 
+// documentSymbols applies the documentation in the .dcm file alongside the
+// legacy library at path, if there is one, to its symbols.
+func (p *ingestPass) documentSymbols(o *origin, path string, symbols []*sym.Symbol) error {
+	docPath := strings.TrimSuffix(path, ".lib") + ".dcm"
+	f, err := os.Open(filepath.Join(p.root, docPath))
+	if os.IsNotExist(err) {
 		return nil
-	})
-
-	return err
-}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p.paths = append(p.paths, docPath)
+	if err := checkFileSize(filepath.Join(p.root, docPath)); err != nil {
+		return err
+	}
 
-func upsertFootprint(source *db.Source, url string, b []byte, fp *pcb.Module) (int, error) {
-	ctx := context.Background()
-	exists, uid, err := db.FootprintExists(ctx, url, db.DB())
+	docs, err := sym.DecodeDocLibrary(f)
 	if err != nil {
-		return 0, err
-	}
-	if exists {
-		return uid, db.UpdateFootprint(ctx, &db.Footprint{UID: uid,
-			Data:     b,
-			URL:      url,
-			SourceID: source.UID,
-			PinCount: len(fp.Pads),
-			Name:     fp.Name,
-			Attr:     strings.Join(fp.Attrs, ","),
-			Tags:     strings.Join(fp.Tags, ","),
-		}, db.DB())
-	}
-	return db.CreateFootprint(ctx, &db.Footprint{
-		Data:     b,
-		URL:      url,
-		SourceID: source.UID,
-		PinCount: len(fp.Pads),
-		Name:     fp.Name,
-		Attr:     strings.Join(fp.Attrs, ","),
-		Tags:     strings.Join(fp.Tags, ","),
-	}, db.DB())
+		fmt.Fprintf(p.log, "[ingest][symbols] Failed parsing %q: %v\n", docPath, err)
+		p.fail(o, docPath, db.MakePartURL(o.url, strings.TrimPrefix(docPath, o.prefix)), err)
+		return nil
+	}
+	for _, s := range symbols {
+		if d, ok := docs[s.Name]; ok {
+			s.Document(d)
+		}
+	}
+	return nil
 }
 
-func upsertSymbol(source *db.Source, url string, b []byte, s *sym.Symbol) (int, error) {
-	ctx := context.Background()
-	exists, uid, err := db.SymbolExists(ctx, url, db.DB())
-	if err != nil {
-		return 0, err
+// ingestFile parses and stores any parts in the file at path, which is
+// relative to the root of the pass. The parts are attributed to the given
+// origin.
+func (p *ingestPass) ingestFile(o *origin, path string) error {
+	if !ingestable(path) {
+		return nil
+	}
+	if err := checkFileSize(filepath.Join(p.root, path)); err != nil {
+		return err
 	}
 
-	fieldData := ""
-	for i := range s.Fields {
-		if s.Fields[i].Value == "" {
-			continue
+	if libtable.IsTableFile(path) {
+		return p.ingestLibTable(o, path)
+	}
+	if strings.HasSuffix(path, ".dcm") {
+		// Read when ingesting the library it documents.
+		return nil
+	}
+	if strings.HasSuffix(path, ".mod") {
+		return p.ingestLegacyLibrary(o, path)
+	}
+	if strings.HasSuffix(path, ".kicad_pcb") {
+		p.boards = append(p.boards, boardFile{origin: o, path: path})
+		return nil
+	}
+
+	if strings.HasSuffix(path, ".kicad_mod") {
+		b, err := ioutil.ReadFile(filepath.Join(p.root, path))
+		if err != nil {
+			return err
 		}
-		fieldData += s.Fields[i].Value
-		if i < (len(s.Fields) - 1) {
-			fieldData += " "
+		url := db.MakePartURL(o.url, strings.TrimPrefix(path, o.prefix))
+		p.scope = append(p.scope, url)
+		p.paths = append(p.paths, path)
+
+		if err := checkParseDepth(b); err != nil {
+			return err
+		}
+		var mod *pcb.Module
+		err = withParseTimeout(p.source.UID, path, func() (err error) {
+			mod, err = pcb.ParseModule(strings.NewReader(string(b)))
+			return err
+		})
+		if abortsIngest(err) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(p.log, "[ingest][footprint] Failed parsing %q: %v\n", path, err)
+			p.fail(o, path, url, err)
+			return nil
 		}
-	}
 
-	pinData := ""
-	for i := range s.Pins {
-		if s.Pins[i].Name == "" {
-			continue
+		hash, err := canonicalHash(mod)
+		if err != nil {
+			return err
+		}
+		p.seen[url] = true
+		return p.sink.footprint(o, url, b, mod, hash, "")
+	} else if strings.HasSuffix(path, ".lib") || strings.HasSuffix(path, ".kicad_sym") {
+		b, err := ioutil.ReadFile(filepath.Join(p.root, path))
+		if err != nil {
+			return err
 		}
-		pinData += s.Pins[i].Name
-		if i < (len(s.Pins) - 1) {
-			pinData += " "
-		}
-	}
-
-	if exists {
-		return uid, db.UpdateSymbol(ctx, &db.Symbol{
-			UID:       uid,
-			Data:      b,
-			URL:       url,
-			SourceID:  source.UID,
-			Name:      s.Name,
-			FieldData: fieldData,
-			PinCount:  len(s.Pins),
-			PinData:   pinData,
-		}, db.DB())
-	}
-	return db.CreateSymbol(ctx, &db.Symbol{
-		UID:       uid,
-		Data:      b,
-		URL:       url,
-		SourceID:  source.UID,
-		Name:      s.Name,
-		FieldData: fieldData,
-		PinCount:  len(s.Pins),
-		PinData:   pinData,
-	}, db.DB())
+		url := db.MakePartURL(o.url, strings.TrimPrefix(path, o.prefix))
+		p.scope = append(p.scope, url)
+		p.paths = append(p.paths, path)
+
+		if strings.HasSuffix(path, ".kicad_sym") {
+			if err := checkParseDepth(b); err != nil {
+				return err
+			}
+		}
+
+		var symbols []*sym.Symbol
+		err = withParseTimeout(p.source.UID, path, func() (err error) {
+			symbols, err = sym.DecodeSymbolLibrary(bytes.NewBuffer(b))
+			return err
+		})
+		if abortsIngest(err) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(p.log, "[ingest][symbols] Failed parsing %q: %v\n", path, err)
+			p.fail(o, path, url, err)
+			return nil
+		}
+		if strings.HasSuffix(path, ".lib") {
+			if err := p.documentSymbols(o, path, symbols); err != nil {
+				return err
+			}
+		}
+
+		for i := range symbols {
+			p.seen[url+"::"+symbols[i].Name] = true
+			if err := p.sink.symbol(o, url+"::"+symbols[i].Name, []byte(symbols[i].RawData), symbols[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
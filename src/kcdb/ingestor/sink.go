@@ -0,0 +1,137 @@
+package ingestor
+
+import (
+	"context"
+	"kcdb/db"
+	"kcdb/sym"
+	"strings"
+
+	"github.com/twitchyliquid64/kcgen/pcb"
+)
+
+// sink receives the parts found by an ingestion pass.
+type sink interface {
+	// footprint stores a footprint parsed from b. board is the path of the
+	// board it was extracted from, if any.
+	footprint(o *origin, url string, b []byte, fp *pcb.Module, hash, board string) error
+	// symbol stores a symbol parsed from b.
+	symbol(o *origin, url string, b []byte, s *sym.Symbol) error
+	// libraryFootprint returns true if a footprint with the given content
+	// hash is known from a library.
+	libraryFootprint(hash string) (bool, error)
+	// registered returns true if the repository is ingested as a source.
+	registered(repoURL string) (bool, error)
+}
+
+// dbSink stores parts in the database, attributing them to the source.
+type dbSink struct {
+	source *db.Source
+}
+
+func (sk *dbSink) libraryFootprint(hash string) (bool, error) {
+	return db.LibraryFootprintExists(context.Background(), hash, db.DB())
+}
+
+func (sk *dbSink) registered(repoURL string) (bool, error) {
+	src, err := registeredSource(repoURL)
+	return src != nil, err
+}
+
+// provide records that the source provides the part at url, if it is from a
+// submodule which other sources may also embed.
+func (sk *dbSink) provide(ctx context.Context, o *origin, url string) error {
+	if o.prefix == "" {
+		return nil
+	}
+	return db.AddPartProvider(ctx, url, sk.source.UID, db.DB())
+}
+
+func (sk *dbSink) footprint(o *origin, url string, b []byte, fp *pcb.Module, hash, board string) error {
+	ctx := context.Background()
+	source := sk.source
+	if err := sk.provide(ctx, o, url); err != nil {
+		return err
+	}
+	exists, uid, err := db.FootprintExists(ctx, url, db.DB())
+	if err != nil {
+		return err
+	}
+	rec := &db.Footprint{
+		Data:        b,
+		URL:         url,
+		SourceID:    source.UID,
+		PinCount:    len(fp.Pads),
+		Name:        fp.Name,
+		Attr:        strings.Join(fp.Attrs, ","),
+		Tags:        strings.Join(fp.Tags, ","),
+		ContentHash: hash,
+		Board:       board,
+		Commit:      o.commit,
+		CommitDate:  o.date,
+	}
+	if exists {
+		rec.UID = uid
+		return db.UpdateFootprint(ctx, rec, db.DB())
+	}
+	_, err = db.CreateFootprint(ctx, rec, db.DB())
+	return err
+}
+
+func (sk *dbSink) symbol(o *origin, url string, b []byte, s *sym.Symbol) error {
+	ctx := context.Background()
+	source := sk.source
+	if err := sk.provide(ctx, o, url); err != nil {
+		return err
+	}
+	exists, uid, err := db.SymbolExists(ctx, url, db.DB())
+	if err != nil {
+		return err
+	}
+
+	fieldData := ""
+	for i := range s.Fields {
+		if s.Fields[i].Value == "" {
+			continue
+		}
+		fieldData += s.Fields[i].Value
+		if i < (len(s.Fields) - 1) {
+			fieldData += " "
+		}
+	}
+
+	pinData := ""
+	for i := range s.Pins {
+		if s.Pins[i].Name == "" {
+			continue
+		}
+		pinData += s.Pins[i].Name
+		if i < (len(s.Pins) - 1) {
+			pinData += " "
+		}
+	}
+
+	rec := &db.Symbol{
+		Data:        b,
+		URL:         url,
+		SourceID:    source.UID,
+		Name:        s.Name,
+		FieldData:   fieldData,
+		PinCount:    len(s.Pins),
+		PinData:     pinData,
+		ContentHash: s.ContentHash(),
+		Description: s.Description,
+		Keywords:    s.Keywords,
+		Datasheet:   s.Datasheet,
+		Units:       s.Units,
+		Power:       s.Power,
+		DeMorgan:    s.DeMorgan,
+		Commit:      o.commit,
+		CommitDate:  o.date,
+	}
+	if exists {
+		rec.UID = uid
+		return db.UpdateSymbol(ctx, rec, db.DB())
+	}
+	_, err = db.CreateSymbol(ctx, rec, db.DB())
+	return err
+}
@@ -0,0 +1,180 @@
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"kcdb/db"
+	"kcdb/webhook"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// maxSubmoduleDepth bounds how deeply nested submodules are followed.
+const maxSubmoduleDepth = 5
+
+// origin describes the repository and commit which parts were ingested from.
+// Parts in a submodule are attributed to the submodule's repository, rather
+// than the source it was found in.
+type origin struct {
+	url    string
+	commit string
+	date   time.Time
+	// prefix is the path of the repository relative to the root of the
+	// source, ending in a slash, or empty for the source itself.
+	prefix string
+}
+
+// submodule is a git submodule of a source which needs to be ingested.
+type submodule struct {
+	origin
+	// changed lists the paths of the files in the submodule, relative to the
+	// root of the source.
+	changed []string
+}
+
+// syncSubmodules checks out the submodules of repo for which want returns
+// true, along with any submodules nested within them. repoURL and prefix
+// identify repo relative to the source. The paths of all submodules, including
+// those not checked out, are also returned.
+func syncSubmodules(ctx context.Context, repo *git.Repository, repoURL, prefix string, auth transport.AuthMethod, want func(path string) bool, depth int) ([]*submodule, []string, error) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+	subs, err := wt.Submodules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		out   []*submodule
+		paths []string
+	)
+	for _, s := range subs {
+		cfg := s.Config()
+		p := prefix + cfg.Path
+		paths = append(paths, p)
+		if !want(p) {
+			continue
+		}
+		cfg.URL = resolveSubmoduleURL(repoURL, cfg.URL)
+
+		if src, err := registeredSource(cfg.URL); err != nil {
+			return nil, nil, err
+		} else if src != nil {
+			fmt.Printf("[ingest][submodule] Skipping %s, which is ingested as source %d.\n", p, src.UID)
+			continue
+		}
+
+		status, err := s.Status()
+		if err != nil {
+			return nil, nil, err
+		}
+		// The source's credentials are only sent to the host they are for.
+		subAuth := auth
+		if !sameHost(repoURL, cfg.URL) {
+			subAuth = nil
+		}
+		if err := s.UpdateContext(ctx, &git.SubmoduleUpdateOptions{Init: true, Auth: subAuth}); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			fmt.Printf("[ingest][submodule] Could not check out %s from %s: %v\n", p, cfg.URL, err)
+			continue
+		}
+		subRepo, err := s.Repository()
+		if err != nil {
+			return nil, nil, err
+		}
+		commit, err := subRepo.CommitObject(status.Expected)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("[ingest][submodule] Checked out %s at %s.\n", p, commit.Hash)
+		out = append(out, &submodule{origin: origin{
+			url:    cfg.URL,
+			commit: commit.Hash.String(),
+			date:   commit.Committer.When,
+			prefix: p + "/",
+		}})
+
+		if depth < maxSubmoduleDepth {
+			nested, nestedPaths, err := syncSubmodules(ctx, subRepo, cfg.URL, p+"/", subAuth, func(string) bool { return true }, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, nested...)
+			paths = append(paths, nestedPaths...)
+		}
+	}
+	return out, paths, nil
+}
+
+// resolveSubmoduleURL resolves a submodule URL which is relative to the URL
+// of its parent repository.
+func resolveSubmoduleURL(parent, sub string) string {
+	if !strings.HasPrefix(sub, "./") && !strings.HasPrefix(sub, "../") {
+		return sub
+	}
+	if u, err := url.Parse(parent); err == nil && u.Scheme != "" {
+		u.Path = path.Join(u.Path, sub)
+		return u.String()
+	}
+	// scp-like syntax, such as git@github.com:user/repo.git
+	if i := strings.Index(parent, ":"); i > 0 {
+		return parent[:i+1] + path.Join(parent[i+1:], sub)
+	}
+	return path.Join(parent, sub)
+}
+
+// sameHost returns true if the repository URLs use the same scheme and host.
+// scp-like URLs, such as git@github.com:user/repo.git, use ssh.
+func sameHost(a, b string) bool {
+	ea, err := transport.NewEndpoint(a)
+	if err != nil {
+		return false
+	}
+	eb, err := transport.NewEndpoint(b)
+	if err != nil {
+		return false
+	}
+	return ea.Protocol == eb.Protocol && ea.Host != "" && strings.EqualFold(ea.Host, eb.Host)
+}
+
+// registeredSource returns the source with the given repository URL, or nil
+// if there is none.
+func registeredSource(repoURL string) (*db.Source, error) {
+	sources, err := db.GetSources(context.Background(), db.DB())
+	if err != nil {
+		return nil, err
+	}
+	n := webhook.NormalizeURL(repoURL)
+	for _, s := range sources {
+		if s.Kind == db.SourceKindGit && webhook.NormalizeURL(s.URL) == n {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// underDir returns true if the slash separated path p is within dir.
+func underDir(p, dir string) bool {
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// deepestSubmodule returns the submodule path which most closely contains p,
+// or the empty string if p is not within a submodule.
+func deepestSubmodule(p string, paths []string) string {
+	var out string
+	for _, s := range paths {
+		if underDir(p, s) && len(s) > len(out) {
+			out = s
+		}
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package ingestor
+
+import "testing"
+
+func TestSameHost(t *testing.T) {
+	tcs := []struct {
+		a, b string
+		want bool
+	}{
+		{"https://github.com/corp/parts", "https://github.com/corp/libs.git", true},
+		{"https://github.com/corp/parts", "https://GitHub.com/other/libs", true},
+		{"git@github.com:corp/parts.git", "git@github.com:corp/libs.git", true},
+		{"ssh://git@github.com/corp/parts.git", "git@github.com:corp/libs.git", true},
+
+		// Credentials must not be sent to another host, or over another
+		// protocol.
+		{"https://git.corp.example/parts", "https://github.com/someone/libs", false},
+		{"git@git.corp.example:parts.git", "git@github.com:someone/libs.git", false},
+		{"https://github.com/corp/parts", "http://github.com/corp/libs", false},
+		{"https://github.com/corp/parts", "git@github.com:corp/libs.git", false},
+		{"https://github.com/corp/parts", "libs", false},
+	}
+	for _, tc := range tcs {
+		if got := sameHost(tc.a, tc.b); got != tc.want {
+			t.Errorf("sameHost(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestResolveSubmoduleURLCrossHost(t *testing.T) {
+	parent := "https://git.corp.example/team/parts"
+	for sub, want := range map[string]bool{
+		"../libs":                          true,
+		"./vendor/libs":                    true,
+		"https://github.com/someone/libs":  false,
+		"git@github.com:someone/libs.git":  false,
+		"https://git.corp.example/x/libs":  true,
+		"https://git.corp.example.evil/xx": false,
+	} {
+		if got := sameHost(parent, resolveSubmoduleURL(parent, sub)); got != want {
+			t.Errorf("submodule %q of %q: credentials sent = %v, want %v", sub, parent, got, want)
+		}
+	}
+}
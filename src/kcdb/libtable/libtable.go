@@ -0,0 +1,168 @@
+// Package libtable reads KiCad fp-lib-table and sym-lib-table files, which
+// list the footprint and symbol libraries used by a project or installation.
+package libtable
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nsf/sexp"
+)
+
+// Table kinds, as given by the name of the top level expression.
+const (
+	KindFootprint = "fp_lib_table"
+	KindSymbol    = "sym_lib_table"
+)
+
+// Lib describes a single library in a table.
+type Lib struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	URI      string `json:"uri"`
+	Options  string `json:"options"`
+	Descr    string `json:"descr"`
+	Disabled bool   `json:"disabled"`
+}
+
+// Table is a parsed library table.
+type Table struct {
+	Kind string `json:"kind"`
+	Libs []Lib  `json:"libs"`
+}
+
+// IsTableFile returns true if the slash separated path names a library table.
+func IsTableFile(p string) bool {
+	base := path.Base(p)
+	return base == "fp-lib-table" || base == "sym-lib-table"
+}
+
+// Decode reads a library table.
+func Decode(r io.RuneReader) (*Table, error) {
+	ast, err := sexp.Parse(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ast.IsList() || ast.NumChildren() != 1 {
+		return nil, errors.New("invalid format: expected a single s-expression list at top level")
+	}
+	mainAST, _ := ast.Nth(0)
+	if !mainAST.IsList() {
+		return nil, errors.New("invalid format: expected s-expression list at 1st level")
+	}
+
+	out := &Table{}
+	out.Kind, err = sexp.Help(mainAST).Child(0).String()
+	if err != nil || (out.Kind != KindFootprint && out.Kind != KindSymbol) {
+		return nil, errors.New("invalid format: missing fp_lib_table or sym_lib_table prefix")
+	}
+
+	for i := 1; i < mainAST.NumChildren(); i++ {
+		n := sexp.Help(mainAST).Child(i)
+		if !n.IsList() {
+			continue
+		}
+		if s, _ := n.Child(0).String(); s != "lib" {
+			// Newer versions of KiCad record a version number.
+			continue
+		}
+		lib, err := decodeLib(n)
+		if err != nil {
+			return nil, fmt.Errorf("lib %d: %v", len(out.Libs)+1, err)
+		}
+		out.Libs = append(out.Libs, *lib)
+	}
+	return out, nil
+}
+
+func decodeLib(n sexp.Helper) (*Lib, error) {
+	lib := &Lib{}
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		c := n.Child(x)
+		if !c.IsList() {
+			continue
+		}
+		key, err := c.Child(0).String()
+		if err != nil {
+			return nil, errors.New("invalid format: expected string key")
+		}
+		if key == "disabled" {
+			lib.Disabled = true
+			continue
+		}
+		var val string
+		if c.Child(1).IsValid() {
+			if val, err = c.Child(1).String(); err != nil {
+				return nil, fmt.Errorf("invalid format: %s value must be a string", key)
+			}
+		}
+		switch key {
+		case "name":
+			lib.Name = val
+		case "type":
+			lib.Type = val
+		case "uri":
+			lib.URI = val
+		case "options":
+			lib.Options = val
+		case "descr":
+			lib.Descr = val
+		}
+	}
+	if lib.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	return lib, nil
+}
+
+// forges lists hosts where the first two path elements of a URL identify a
+// git repository.
+var forges = map[string]bool{
+	"github.com":    true,
+	"gitlab.com":    true,
+	"bitbucket.org": true,
+	"codeberg.org":  true,
+}
+
+// RepoURL returns the URL of the git repository which a library URI refers
+// to, if it refers to one. URIs which are local paths, or which use
+// environment variables such as ${KIPRJMOD}, are not repositories.
+func RepoURL(uri string) (string, bool) {
+	s := strings.TrimSpace(uri)
+	if s == "" || strings.Contains(s, "${") || strings.Contains(s, "$(") {
+		return "", false
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+
+	var parts []string
+	for _, p := range strings.Split(u.Path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if forges[host] {
+		if len(parts) < 2 {
+			return "", false
+		}
+		parts = parts[:2]
+	} else {
+		// Elsewhere, only URIs which name a repository explicitly are used.
+		if len(parts) == 0 || !strings.HasSuffix(parts[len(parts)-1], ".git") {
+			return "", false
+		}
+	}
+	parts[len(parts)-1] = strings.TrimSuffix(parts[len(parts)-1], ".git")
+	return "https://" + host + "/" + strings.Join(parts, "/"), true
+}
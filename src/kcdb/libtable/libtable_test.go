@@ -0,0 +1,84 @@
+package libtable
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	out, err := Decode(strings.NewReader(`(fp_lib_table
+  (lib (name Audio_Module)(type KiCad)(uri ${KISYSMOD}/Audio_Module.pretty)(options "")(descr "Audio Module footprints"))
+  (lib (name Connector_Arduino)(type Github)(uri https://github.com/KiCad/Connector_Arduino.pretty)(options "")(descr ""))
+  (lib (name project)(type KiCad)(uri "${KIPRJMOD}/lib/project.pretty")(options "")(descr "")(disabled))
+)
+`))
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if out.Kind != KindFootprint {
+		t.Errorf("Kind = %q, want %q", out.Kind, KindFootprint)
+	}
+	if len(out.Libs) != 3 {
+		t.Fatalf("got %d libs, want 3", len(out.Libs))
+	}
+	want := Lib{Name: "Connector_Arduino", Type: "Github", URI: "https://github.com/KiCad/Connector_Arduino.pretty"}
+	if out.Libs[1] != want {
+		t.Errorf("Libs[1] = %+v, want %+v", out.Libs[1], want)
+	}
+	if out.Libs[0].Descr != "Audio Module footprints" {
+		t.Errorf("Libs[0].Descr = %q", out.Libs[0].Descr)
+	}
+	if !out.Libs[2].Disabled || out.Libs[2].URI != "${KIPRJMOD}/lib/project.pretty" {
+		t.Errorf("Libs[2] = %+v, want disabled project library", out.Libs[2])
+	}
+}
+
+func TestDecodeSymbolTable(t *testing.T) {
+	out, err := Decode(strings.NewReader(`(sym_lib_table
+  (version 7)
+  (lib (name "Device")(type "KiCad")(uri "${KICAD7_SYMBOL_DIR}/Device.kicad_sym")(options "")(descr "Generic symbols"))
+)`))
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if out.Kind != KindSymbol || len(out.Libs) != 1 || out.Libs[0].Name != "Device" {
+		t.Errorf("Decode() = %+v", out)
+	}
+}
+
+func TestDecodeRejectsOtherFiles(t *testing.T) {
+	if _, err := Decode(strings.NewReader(`(module R_0603 (layer F.Cu))`)); err == nil {
+		t.Error("expected error decoding a footprint")
+	}
+	if _, err := Decode(strings.NewReader(`(fp_lib_table (lib (type KiCad)))`)); err == nil {
+		t.Error("expected error decoding a library without a name")
+	}
+}
+
+func TestRepoURL(t *testing.T) {
+	tcs := []struct {
+		uri  string
+		want string
+		ok   bool
+	}{
+		{"https://github.com/KiCad/Connector_Arduino.pretty", "https://github.com/KiCad/Connector_Arduino.pretty", true},
+		{"https://github.com/user/kicad-libs/tree/master/lib.pretty", "https://github.com/user/kicad-libs", true},
+		{"https://www.github.com/user/repo.git", "https://github.com/user/repo", true},
+		{"github.com/user/repo/symbols.lib", "https://github.com/user/repo", true},
+		{"https://gitlab.com/group/lib/-/raw/master/x.kicad_sym", "https://gitlab.com/group/lib", true},
+		{"https://git.example.com/libs/parts.git", "https://git.example.com/libs/parts", true},
+		{"https://example.com/downloads/parts.pretty", "", false},
+		{"https://github.com/user", "", false},
+		{"${KIPRJMOD}/lib.pretty", "", false},
+		{"${KISYSMOD}/Audio_Module.pretty", "", false},
+		{"/usr/share/kicad/modules/Resistor_SMD.pretty", "", false},
+		{"ftp://github.com/user/repo", "", false},
+		{"", "", false},
+	}
+	for _, tc := range tcs {
+		got, ok := RepoURL(tc.uri)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("RepoURL(%q) = %q, %v, want %q, %v", tc.uri, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 
 	"github.com/nsf/sexp"
 )
@@ -28,9 +30,27 @@ type FpCircle struct {
 	End    Point2D `json:"end"`
 	Layer  string  `json:"layer"`
 	Width  float64 `json:"width"`
+	Fill   bool    `json:"fill,omitempty"`
 }
 
-// FpArc represents a graphical arc.
+// FpRect represents a graphical rectangle.
+type FpRect struct {
+	Start Point2D `json:"start"`
+	End   Point2D `json:"end"`
+	Layer string  `json:"layer"`
+	Width float64 `json:"width"`
+	Fill  bool    `json:"fill,omitempty"`
+}
+
+// FpCurve represents a graphical cubic bezier curve.
+type FpCurve struct {
+	Points []Point2D `json:"points"`
+	Layer  string    `json:"layer"`
+	Width  float64   `json:"width"`
+}
+
+// FpArc represents a graphical arc, by its center (start), the point it
+// starts from (end) and the angle it sweeps clockwise in degrees.
 type FpArc struct {
 	Start Point2D `json:"start"`
 	End   Point2D `json:"end"`
@@ -59,6 +79,19 @@ type FpText struct {
 	Thickness float64 `json:"thickness"`
 }
 
+// FpZone represents a zone, such as a keepout area, in a footprint.
+type FpZone struct {
+	Layers   []string    `json:"layers"`
+	Keepout  bool        `json:"keepout"`
+	Polygons [][]Point2D `json:"polygons"`
+}
+
+// FpProperty represents a property of a KiCad 7+ footprint.
+type FpProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 // Pad represents a pad in a component footprint.
 type Pad struct {
 	Pin   int    `json:"pin"`
@@ -81,7 +114,10 @@ type Drill struct {
 
 // Module represents a Kicad module.
 type Module struct {
-	Name        string  `json:"name"`
+	Name string `json:"name"`
+	// Version and Generator are set for footprints saved by KiCad 6 or later.
+	Version     int     `json:"version,omitempty"`
+	Generator   string  `json:"generator,omitempty"`
 	Tedit       string  `json:"tedit"`
 	Description string  `json:"description"`
 	Layer       string  `json:"layer"`
@@ -93,17 +129,23 @@ type Module struct {
 	SolderPasteMargin float64 `json:"solder_paste_margin,omitempty"`
 	SolderPasteRatio  float64 `json:"solder_paste_ratio,omitempty"`
 
-	Tags     []string   `json:"tags"`
-	Attrs    []string   `json:"attrs"`
-	Lines    []FpLine   `json:"lines"`
-	Arcs     []FpArc    `json:"arcs"`
-	Circles  []FpCircle `json:"circles"`
-	Polygons []FpPoly   `json:"polygons"`
-	Texts    []FpText   `json:"texts"`
-	Pads     []Pad      `json:"pads"`
+	Tags       []string     `json:"tags"`
+	Attrs      []string     `json:"attrs"`
+	Properties []FpProperty `json:"properties,omitempty"`
+	Lines      []FpLine     `json:"lines"`
+	Arcs       []FpArc      `json:"arcs"`
+	Circles    []FpCircle   `json:"circles"`
+	Rects      []FpRect     `json:"rects,omitempty"`
+	Curves     []FpCurve    `json:"curves,omitempty"`
+	Polygons   []FpPoly     `json:"polygons"`
+	Texts      []FpText     `json:"texts"`
+	Pads       []Pad        `json:"pads"`
+	Zones      []FpZone     `json:"zones,omitempty"`
 }
 
-// DecodeModule reads a .kicad_mod file from a reader.
+// DecodeModule reads a .kicad_mod file from a reader. Both the module
+// syntax of KiCad 5 and earlier and the footprint syntax of KiCad 6+ are
+// supported.
 func DecodeModule(r io.RuneReader) (*Module, error) {
 	out := &Module{}
 	ast, err := sexp.Parse(r, nil)
@@ -122,7 +164,7 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 	if mainAST.NumChildren() < 3 {
 		return nil, errors.New("invalid format: missing minimum elements")
 	}
-	if s, err2 := sexp.Help(mainAST).Child(0).String(); err2 != nil || s != "module" {
+	if s, err2 := sexp.Help(mainAST).Child(0).String(); err2 != nil || (s != "module" && s != "footprint") {
 		return nil, errors.New("invalid format: missing module prefix")
 	}
 
@@ -135,8 +177,22 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 		n := sexp.Help(mainAST).Child(i)
 		if n.IsList() && n.Child(1).IsValid() {
 			switch n.Child(0).MustString() {
-			case "zone_connect", "path", "autoplace_cost90", "autoplace_cost180":
+			case "zone_connect", "path", "autoplace_cost90", "autoplace_cost180",
+				"tstamp", "uuid", "group", "generator_version", "embedded_fonts", "embedded_files",
+				"net_tie_pad_groups", "private_layers", "sheetname", "sheetfile", "solder_paste_margin_ratio":
 				// ignore
+			case "fp_text_box", "dimension", "image":
+				// Annotations which are not part of the footprint's geometry.
+			case "version":
+				out.Version, err = n.Child(1).Int()
+				if err != nil {
+					return nil, errors.New("invalid format: version value must be an integer")
+				}
+			case "generator":
+				out.Generator, err = n.Child(1).String()
+				if err != nil {
+					return nil, errors.New("invalid format: generator value must be a string")
+				}
 			case "layer":
 				out.Layer, err = n.Child(1).String()
 				if err != nil {
@@ -155,7 +211,7 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 			case "tags":
 				for x := 1; x < n.MustNode().NumChildren(); x++ {
 					var t string
-					t, err = n.Child(1).String()
+					t, err = n.Child(x).String()
 					if err != nil {
 						return nil, errors.New("invalid format: tag value must be a string")
 					}
@@ -164,7 +220,7 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 			case "attr":
 				for x := 1; x < n.MustNode().NumChildren(); x++ {
 					var t string
-					t, err = n.Child(1).String()
+					t, err = n.Child(x).String()
 					if err != nil {
 						return nil, errors.New("invalid format: tag value must be a string")
 					}
@@ -203,6 +259,18 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 					return nil, err
 				}
 				out.Arcs = append(out.Arcs, a)
+			case "fp_rect":
+				r, err := unmarshalFpRect(n)
+				if err != nil {
+					return nil, err
+				}
+				out.Rects = append(out.Rects, r)
+			case "fp_curve":
+				c, err := unmarshalFpCurve(n)
+				if err != nil {
+					return nil, err
+				}
+				out.Curves = append(out.Curves, c)
 			case "fp_poly":
 				p, err := unmarshalFpPoly(n)
 				if err != nil {
@@ -215,6 +283,32 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 					return nil, err
 				}
 				out.Texts = append(out.Texts, txt)
+			case "property":
+				name, err := n.Child(1).String()
+				if err != nil {
+					return nil, errors.New("invalid format: property name must be a string")
+				}
+				// KiCad 8 stores the reference and value text as properties.
+				if name == "Reference" || name == "Value" {
+					txt, err := unmarshalFpText(n)
+					if err != nil {
+						return nil, err
+					}
+					txt.Kind = strings.ToLower(name)
+					out.Texts = append(out.Texts, txt)
+					continue
+				}
+				value, err := n.Child(2).String()
+				if err != nil {
+					return nil, errors.New("invalid format: property value must be a string")
+				}
+				out.Properties = append(out.Properties, FpProperty{Name: name, Value: value})
+			case "zone":
+				z, err := unmarshalZone(n)
+				if err != nil {
+					return nil, err
+				}
+				out.Zones = append(out.Zones, z)
 			case "pad":
 				pad, err := unmarshalPad(n)
 				if err != nil {
@@ -231,28 +325,112 @@ func DecodeModule(r io.RuneReader) (*Module, error) {
 	return out, nil
 }
 
+// unmarshalFpArc decodes an arc. KiCad 6+ describes arcs by their start,
+// middle and end points, which are converted to a center and angle.
 func unmarshalFpArc(n sexp.Helper) (FpArc, error) {
 	arc := FpArc{}
+	var mid *Point2D
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
 		switch n.Child(x).Child(0).MustString() {
 		case "start":
 			arc.Start = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
+		case "mid":
+			mid = &Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
 		case "end":
 			arc.End = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
 		case "layer":
 			arc.Layer = n.Child(x).Child(1).MustString()
 		case "width":
 			arc.Width = n.Child(x).Child(1).MustFloat64()
+		case "stroke":
+			arc.Width = strokeWidth(n.Child(x))
 		case "angle":
 			arc.Angle = n.Child(x).Child(1).MustFloat64()
 		}
 	}
+
+	if mid != nil {
+		center, angle, ok := arcFromPoints(arc.Start, *mid, arc.End)
+		if !ok {
+			return FpArc{}, errors.New("invalid format: arc points are collinear")
+		}
+		arc.Start, arc.End, arc.Angle = center, arc.Start, angle
+	}
 	return arc, nil
 }
 
+// arcFromPoints returns the center of the arc which passes through start, mid
+// and end, and the angle in degrees it sweeps from start to end. ok is false
+// if the points are collinear.
+func arcFromPoints(start, mid, end Point2D) (center Point2D, angle float64, ok bool) {
+	d := 2 * (start.X*(mid.Y-end.Y) + mid.X*(end.Y-start.Y) + end.X*(start.Y-mid.Y))
+	if math.Abs(d) < 1e-9 {
+		return Point2D{}, 0, false
+	}
+	s2 := start.X*start.X + start.Y*start.Y
+	m2 := mid.X*mid.X + mid.Y*mid.Y
+	e2 := end.X*end.X + end.Y*end.Y
+	center = Point2D{
+		X: round6((s2*(mid.Y-end.Y) + m2*(end.Y-start.Y) + e2*(start.Y-mid.Y)) / d),
+		Y: round6((s2*(end.X-mid.X) + m2*(start.X-end.X) + e2*(mid.X-start.X)) / d),
+	}
+
+	// Angles increase clockwise, as the y axis points down.
+	startAngle := pointAngle(center, start)
+	sweep := normalizeAngle(pointAngle(center, end) - startAngle)
+	if normalizeAngle(pointAngle(center, mid)-startAngle) > sweep {
+		// The middle point is only reached going anticlockwise.
+		sweep -= 360
+	}
+	if sweep == 0 {
+		sweep = 360
+	}
+	return center, round6(sweep), true
+}
+
+func pointAngle(center, p Point2D) float64 {
+	return math.Atan2(p.Y-center.Y, p.X-center.X) * 180 / math.Pi
+}
+
+// normalizeAngle returns the angle in the range [0, 360).
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+func round6(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// strokeWidth returns the width given by a KiCad 6+ stroke expression.
+func strokeWidth(n sexp.Helper) float64 {
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsList() && n.Child(x).Child(0).MustString() == "width" {
+			return n.Child(x).Child(1).MustFloat64()
+		}
+	}
+	return 0
+}
+
+// filled returns whether a fill expression fills the shape, which is written
+// as (fill solid) by KiCad 6 & 7 and (fill yes) by KiCad 8.
+func filled(n sexp.Helper) bool {
+	v, _ := n.Child(1).String()
+	return v == "solid" || v == "yes"
+}
+
 func unmarshalFpCircle(n sexp.Helper) (FpCircle, error) {
 	circle := FpCircle{}
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
 		switch n.Child(x).Child(0).MustString() {
 		case "center":
 			circle.Center = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
@@ -262,29 +440,150 @@ func unmarshalFpCircle(n sexp.Helper) (FpCircle, error) {
 			circle.Layer = n.Child(x).Child(1).MustString()
 		case "width":
 			circle.Width = n.Child(x).Child(1).MustFloat64()
+		case "stroke":
+			circle.Width = strokeWidth(n.Child(x))
+		case "fill":
+			circle.Fill = filled(n.Child(x))
 		}
 	}
 	return circle, nil
 }
 
+func unmarshalFpRect(n sexp.Helper) (FpRect, error) {
+	rect := FpRect{}
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
+		switch n.Child(x).Child(0).MustString() {
+		case "start":
+			rect.Start = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
+		case "end":
+			rect.End = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
+		case "layer":
+			rect.Layer = n.Child(x).Child(1).MustString()
+		case "width":
+			rect.Width = n.Child(x).Child(1).MustFloat64()
+		case "stroke":
+			rect.Width = strokeWidth(n.Child(x))
+		case "fill":
+			rect.Fill = filled(n.Child(x))
+		}
+	}
+	return rect, nil
+}
+
+func unmarshalFpCurve(n sexp.Helper) (FpCurve, error) {
+	c := FpCurve{}
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
+		switch n.Child(x).Child(0).MustString() {
+		case "pts":
+			pts, err := unmarshalPoints(n.Child(x), "fp_curve")
+			if err != nil {
+				return FpCurve{}, err
+			}
+			c.Points = pts
+		case "layer":
+			c.Layer = n.Child(x).Child(1).MustString()
+		case "width":
+			c.Width = n.Child(x).Child(1).MustFloat64()
+		case "stroke":
+			c.Width = strokeWidth(n.Child(x))
+		}
+	}
+	if len(c.Points) != 4 {
+		return FpCurve{}, fmt.Errorf("invalid format: expected 4 points in fp_curve, got %d", len(c.Points))
+	}
+	return c, nil
+}
+
+// unmarshalPoints decodes a pts stanza. Arcs, which KiCad 7+ allows in
+// polygons, are approximated by straight segments.
+func unmarshalPoints(n sexp.Helper, kind string) ([]Point2D, error) {
+	var out []Point2D
+	for i := 1; i < n.MustNode().NumChildren(); i++ {
+		switch n.Child(i).Child(0).MustString() {
+		case "xy":
+			out = append(out, Point2D{X: n.Child(i).Child(1).MustFloat64(), Y: n.Child(i).Child(2).MustFloat64()})
+		case "arc":
+			pts, err := unmarshalArcPoints(n.Child(i))
+			if err != nil {
+				return nil, err
+			}
+			// The arc usually starts at the previous point.
+			if len(out) > 0 && out[len(out)-1] == pts[0] {
+				pts = pts[1:]
+			}
+			out = append(out, pts...)
+		default:
+			return nil, fmt.Errorf("cannot handle expression of type %q in %s.pts stanza", n.Child(i).Child(0).MustString(), kind)
+		}
+	}
+	return out, nil
+}
+
+// arcSegmentAngle is the angle in degrees swept by each segment used to
+// approximate an arc in a polygon.
+const arcSegmentAngle = 10
+
+// unmarshalArcPoints decodes an arc in a pts stanza into the points of
+// straight segments which approximate it, from its start to its end.
+func unmarshalArcPoints(n sexp.Helper) ([]Point2D, error) {
+	var start, mid, end Point2D
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		p := Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
+		switch n.Child(x).Child(0).MustString() {
+		case "start":
+			start = p
+		case "mid":
+			mid = p
+		case "end":
+			end = p
+		}
+	}
+	center, sweep, ok := arcFromPoints(start, mid, end)
+	if !ok {
+		return []Point2D{start, end}, nil
+	}
+
+	segments := int(math.Ceil(math.Abs(sweep) / arcSegmentAngle))
+	radius := math.Hypot(start.X-center.X, start.Y-center.Y)
+	startAngle := pointAngle(center, start)
+	out := []Point2D{start}
+	for i := 1; i < segments; i++ {
+		a := (startAngle + sweep*float64(i)/float64(segments)) * math.Pi / 180
+		out = append(out, Point2D{
+			X: round6(center.X + radius*math.Cos(a)),
+			Y: round6(center.Y + radius*math.Sin(a)),
+		})
+	}
+	return append(out, end), nil
+}
+
 func unmarshalFpPoly(n sexp.Helper) (FpPoly, error) {
 	p := FpPoly{}
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
 		switch n.Child(x).Child(0).MustString() {
 		case "at":
 			p.At = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
 		case "pts":
-			for i := 1; i < n.Child(x).MustNode().NumChildren(); i++ {
-				if n.Child(x).Child(i).Child(0).MustString() == "xy" {
-					p.Points = append(p.Points, Point2D{X: n.Child(x).Child(i).Child(1).MustFloat64(), Y: n.Child(x).Child(i).Child(2).MustFloat64()})
-				} else {
-					return FpPoly{}, fmt.Errorf("cannot handle expression of type %q in fp_poly.pts stanza", n.Child(x).Child(i).Child(0).MustString())
-				}
+			pts, err := unmarshalPoints(n.Child(x), "fp_poly")
+			if err != nil {
+				return FpPoly{}, err
 			}
+			p.Points = pts
 		case "layer":
 			p.Layer = n.Child(x).Child(1).MustString()
 		case "width":
 			p.Width = n.Child(x).Child(1).MustFloat64()
+		case "stroke":
+			p.Width = strokeWidth(n.Child(x))
 		}
 	}
 	return p, nil
@@ -293,6 +592,9 @@ func unmarshalFpPoly(n sexp.Helper) (FpPoly, error) {
 func unmarshalFpLine(n sexp.Helper) (FpLine, error) {
 	line := FpLine{}
 	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
 		switch n.Child(x).Child(0).MustString() {
 		case "start":
 			line.Start = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
@@ -302,11 +604,37 @@ func unmarshalFpLine(n sexp.Helper) (FpLine, error) {
 			line.Layer = n.Child(x).Child(1).MustString()
 		case "width":
 			line.Width = n.Child(x).Child(1).MustFloat64()
+		case "stroke":
+			line.Width = strokeWidth(n.Child(x))
 		}
 	}
 	return line, nil
 }
 
+func unmarshalZone(n sexp.Helper) (FpZone, error) {
+	z := FpZone{}
+	for x := 1; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			continue
+		}
+		switch n.Child(x).Child(0).MustString() {
+		case "layer", "layers":
+			for i := 1; i < n.Child(x).MustNode().NumChildren(); i++ {
+				z.Layers = append(z.Layers, n.Child(x).Child(i).MustString())
+			}
+		case "keepout":
+			z.Keepout = true
+		case "polygon":
+			pts, err := unmarshalPoints(n.Child(x).Child(1), "zone.polygon")
+			if err != nil {
+				return FpZone{}, err
+			}
+			z.Polygons = append(z.Polygons, pts)
+		}
+	}
+	return z, nil
+}
+
 func unmarshalFpText(n sexp.Helper) (FpText, error) {
 	txt := FpText{
 		Kind:  n.Child(1).MustString(),
@@ -329,6 +657,8 @@ func unmarshalFpText(n sexp.Helper) (FpText, error) {
 			txt.Pos = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
 		case "layer":
 			txt.Layer = n.Child(x).Child(1).MustString()
+		case "hide":
+			txt.Hidden = n.Child(x).Child(1).MustString() == "yes"
 		case "effects":
 			s := n.Child(x).Child(1)
 			for i := 1; i < s.MustNode().NumChildren(); i++ {
@@ -384,6 +714,10 @@ func unmarshalPad(n sexp.Helper) (Pad, error) {
 	}
 
 	for x := 4; x < n.MustNode().NumChildren(); x++ {
+		if n.Child(x).IsScalar() {
+			// Such as locked, in KiCad 6+.
+			continue
+		}
 		switch n.Child(x).Child(0).MustString() {
 		case "at":
 			pad.Pos = Point2D{X: n.Child(x).Child(1).MustFloat64(), Y: n.Child(x).Child(2).MustFloat64()}
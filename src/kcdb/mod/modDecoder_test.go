@@ -1,6 +1,9 @@
 package mod
 
 import (
+	"bufio"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -126,3 +129,116 @@ func TestDecodeModPoly(t *testing.T) {
 		t.Errorf("Expected value and no error, got err = %v, out = %+v", err, out)
 	}
 }
+
+func decodeTestdata(t *testing.T, name string) *Module {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err := DecodeModule(bufio.NewReader(f))
+	if err != nil {
+		t.Fatalf("DecodeModule(%q) failed: %v", name, err)
+	}
+	return out
+}
+
+func TestDecodeModKicad6(t *testing.T) {
+	out := decodeTestdata(t, "LED_D5.0mm_v6.kicad_mod")
+	if out.Name != "LED_D5.0mm" || out.Version != 20211014 || out.Generator != "pcbnew" {
+		t.Errorf("Unexpected header: name = %q, version = %d, generator = %q", out.Name, out.Version, out.Generator)
+	}
+	if len(out.Texts) != 3 || len(out.Lines) != 2 || len(out.Circles) != 1 || len(out.Pads) != 2 {
+		t.Fatalf("Unexpected counts: %d texts, %d lines, %d circles, %d pads", len(out.Texts), len(out.Lines), len(out.Circles), len(out.Pads))
+	}
+	if want := (FpRect{Start: Point2D{-1.95, -3.25}, End: Point2D{4.5, 3.25}, Layer: "F.CrtYd", Width: 0.05}); len(out.Rects) != 1 || out.Rects[0] != want {
+		t.Errorf("Rects = %+v, want [%+v]", out.Rects, want)
+	}
+	// The arc from (-2, 0) through (0, -2) to (2, 0) is the top half of a circle.
+	if want := (FpArc{End: Point2D{-2, 0}, Angle: 180, Layer: "F.Fab", Width: 0.1}); len(out.Arcs) != 1 || out.Arcs[0] != want {
+		t.Errorf("Arcs = %+v, want [%+v]", out.Arcs, want)
+	}
+	if out.Pads[1].Pin != 2 || out.Pads[1].Drill.Scalar != 0.9 {
+		t.Errorf("Unexpected pad: %+v", out.Pads[1])
+	}
+}
+
+func TestDecodeModKicad7(t *testing.T) {
+	out := decodeTestdata(t, "SOT-23_v7.kicad_mod")
+	if len(out.Lines) != 2 || out.Lines[0].Width != 0.12 {
+		t.Errorf("Expected 2 lines with their width taken from the stroke, got %+v", out.Lines)
+	}
+	if len(out.Polygons) != 1 || out.Polygons[0].Width != 0.12 || len(out.Polygons[0].Points) != 4 {
+		t.Errorf("Unexpected polygons: %+v", out.Polygons)
+	}
+	if len(out.Arcs) != 1 || out.Arcs[0].End != (Point2D{0.65, -1.45}) || out.Arcs[0].Angle > -180 {
+		t.Errorf("Expected an anticlockwise arc of more than 180 degrees, got %+v", out.Arcs)
+	}
+	if len(out.Properties) != 2 || out.Properties[0] != (FpProperty{Name: "Sheetfile", Value: "power.kicad_sch"}) {
+		t.Errorf("Unexpected properties: %+v", out.Properties)
+	}
+	if len(out.Zones) != 1 || !out.Zones[0].Keepout || len(out.Zones[0].Polygons) != 1 || len(out.Zones[0].Polygons[0]) != 4 {
+		t.Errorf("Unexpected zones: %+v", out.Zones)
+	}
+	if len(out.Pads) != 3 || out.Pads[2].Pin != 3 || out.Pads[2].Shape != "roundrect" {
+		t.Errorf("Unexpected pads: %+v", out.Pads)
+	}
+}
+
+func TestDecodeModKicad8(t *testing.T) {
+	out := decodeTestdata(t, "TestPoint_Pad_D1.0mm_v8.kicad_mod")
+	if out.Version != 20240108 {
+		t.Errorf("Version = %d, want 20240108", out.Version)
+	}
+	if len(out.Attrs) != 2 || out.Attrs[1] != "exclude_from_pos_files" {
+		t.Errorf("Attrs = %v, want [smd exclude_from_pos_files]", out.Attrs)
+	}
+	if len(out.Texts) != 3 {
+		t.Fatalf("Expected 3 texts, got %d", len(out.Texts))
+	}
+	if txt := out.Texts[1]; txt.Kind != "value" || txt.Value != "TestPoint_Pad_D1.0mm" || txt.Layer != "F.Fab" || txt.Hidden {
+		t.Errorf("Unexpected value text: %+v", txt)
+	}
+	if len(out.Properties) != 3 || out.Properties[2].Name != "Description" {
+		t.Errorf("Unexpected properties: %+v", out.Properties)
+	}
+	if len(out.Circles) != 2 || out.Circles[0].Width != 0.12 {
+		t.Errorf("Unexpected circles: %+v", out.Circles)
+	}
+	if len(out.Curves) != 1 || len(out.Curves[0].Points) != 4 || out.Curves[0].Layer != "F.SilkS" {
+		t.Errorf("Unexpected curves: %+v", out.Curves)
+	}
+	if len(out.Pads) != 1 || out.Pads[0].Pin != 1 {
+		t.Errorf("Unexpected pads: %+v", out.Pads)
+	}
+}
+
+func TestDecodeModAnnotationsAndPolygonArcs(t *testing.T) {
+	out := decodeTestdata(t, "Fiducial_v7_annotated.kicad_mod")
+	if len(out.Texts) != 2 {
+		t.Errorf("Expected the text box and dimension text to be ignored, got texts %+v", out.Texts)
+	}
+	if len(out.Circles) != 1 || len(out.Pads) != 1 {
+		t.Errorf("Unexpected circles %+v or pads %+v", out.Circles, out.Pads)
+	}
+	if len(out.Polygons) != 1 {
+		t.Fatalf("Expected 1 polygon, got %+v", out.Polygons)
+	}
+	// The 180 degree arc is approximated by 18 segments, whose first point
+	// is the polygon's second point.
+	pts := out.Polygons[0].Points
+	if len(pts) != 21 {
+		t.Fatalf("Expected 21 points, got %d: %+v", len(pts), pts)
+	}
+	if pts[1] != (Point2D{-1, -1}) || pts[10] != (Point2D{0, -2}) || pts[19] != (Point2D{1, -1}) || pts[20] != (Point2D{1, 1}) {
+		t.Errorf("Unexpected polygon points: %+v", pts)
+	}
+}
+
+func TestDecodeModUnknownExpression(t *testing.T) {
+	_, err := DecodeModule(strings.NewReader(`(footprint "X" (layer "F.Cu") (frobnicate 1))`))
+	if err == nil {
+		t.Error("Expected error for unknown expression")
+	}
+}
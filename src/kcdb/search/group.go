@@ -0,0 +1,52 @@
+package search
+
+// fetchLimit is how many parts are fetched for a search, so enough distinct
+// parts remain once copies are collapsed. resultLimit is how many distinct
+// parts are returned.
+const (
+	fetchLimit  = 500
+	resultLimit = 65
+)
+
+// group collapses parts with the same content hash, given the hash and
+// privacy of each part in order of rank. The index of the representative
+// of each group is returned, which is the best ranked public copy, or the
+// best ranked copy if every copy is private. Parts without a hash are never
+// collapsed.
+func group(n int, hash func(i int) string, private func(i int) bool) []int {
+	var out []int
+	groups := map[string]int{} // hash -> index in out
+	for i := 0; i < n; i++ {
+		h := hash(i)
+		if h == "" {
+			out = append(out, i)
+			continue
+		}
+		g, ok := groups[h]
+		if !ok {
+			groups[h] = len(out)
+			out = append(out, i)
+			continue
+		}
+		if private(out[g]) && !private(i) {
+			out[g] = i
+		}
+	}
+	if len(out) > resultLimit {
+		out = out[:resultLimit]
+	}
+	return out
+}
+
+// distinctHashes returns the non-empty hashes of the given parts.
+func distinctHashes(idx []int, hash func(i int) string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, i := range idx {
+		if h := hash(i); h != "" && !seen[h] {
+			seen[h] = true
+			out = append(out, h)
+		}
+	}
+	return out
+}
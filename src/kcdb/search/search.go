@@ -29,6 +29,7 @@ func rank(ctx context.Context, fps []*db.Footprint) ([]*db.Footprint, error) {
 			}
 		}
 		fp.Rank = -src.Rank
+		fp.Private = src.Private
 	}
 	s = byRank(fps)
 	sort.Sort(s)
@@ -44,8 +45,9 @@ func (e ErrBadQuery) Error() string {
 	return e.msg
 }
 
-// Search returns search results.
-func Search(ctx context.Context, q string) ([]*db.Footprint, error) {
+// Search returns search results. Footprints from private sources are only
+// included if includePrivate is set.
+func Search(ctx context.Context, q string, includePrivate bool) ([]*db.Footprint, error) {
 	var params db.FpSearchParam
 	var err error
 
@@ -72,9 +74,53 @@ func Search(ctx context.Context, q string) ([]*db.Footprint, error) {
 		return nil, ErrBadQuery{msg: "Keywords must be specified"}
 	}
 
+	params.Limit = fetchLimit
 	fps, err := db.FootprintSearch(ctx, params, db.DB())
 	if err != nil {
 		return nil, err
 	}
-	return rank(ctx, fps)
+	if fps, err = rank(ctx, fps); err != nil {
+		return nil, err
+	}
+	if !includePrivate {
+		fps = publicFootprints(fps)
+	}
+	return collapse(ctx, fps)
+}
+
+// publicFootprints returns the footprints which are not from private sources.
+func publicFootprints(fps []*db.Footprint) []*db.Footprint {
+	out := fps[:0]
+	for _, fp := range fps {
+		if !fp.Private {
+			out = append(out, fp)
+		}
+	}
+	return out
+}
+
+// collapse returns one footprint for each set of identical footprints, noting
+// how many other sources contain it.
+func collapse(ctx context.Context, fps []*db.Footprint) ([]*db.Footprint, error) {
+	hash := func(i int) string { return fps[i].ContentHash }
+	idx := group(len(fps), hash, func(i int) bool { return fps[i].Private })
+	counts, err := db.FootprintHashSources(ctx, distinctHashes(idx, hash), db.DB())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*db.Footprint, len(idx))
+	for i, x := range idx {
+		out[i] = fps[x]
+		// Only public sources are counted, so a private part is not
+		// among them.
+		n := counts[out[i].ContentHash]
+		if !out[i].Private {
+			n--
+		}
+		if n > 0 {
+			out[i].AlsoFoundIn = n
+		}
+	}
+	return out, nil
 }
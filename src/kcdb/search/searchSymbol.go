@@ -29,14 +29,16 @@ func rankSym(ctx context.Context, syms []*db.Symbol) ([]*db.Symbol, error) {
 			}
 		}
 		fp.Rank = -src.Rank
+		fp.Private = src.Private
 	}
 	s = byRankSym(syms)
 	sort.Sort(s)
 	return s, nil
 }
 
-// SymbolSearch returns search results when searching for symbols.
-func SymbolSearch(ctx context.Context, q string) ([]*db.Symbol, error) {
+// SymbolSearch returns search results when searching for symbols. Symbols
+// from private sources are only included if includePrivate is set.
+func SymbolSearch(ctx context.Context, q string, includePrivate bool) ([]*db.Symbol, error) {
 	var params db.SymSearchParam
 	var err error
 
@@ -49,6 +51,17 @@ func SymbolSearch(ctx context.Context, q string) ([]*db.Symbol, error) {
 				if err != nil {
 					return nil, err
 				}
+			case "units", "unit_count", "u":
+				params.Units, err = strconv.Atoi(spl[1])
+				if err != nil {
+					return nil, err
+				}
+			case "power", "pwr":
+				power, err := parseFlag(spl[1])
+				if err != nil {
+					return nil, err
+				}
+				params.Power = &power
 			default:
 				return nil, fmt.Errorf("could not understand specifier %q", spl[0])
 			}
@@ -57,13 +70,70 @@ func SymbolSearch(ctx context.Context, q string) ([]*db.Symbol, error) {
 		}
 	}
 
-	if len(params.Keywords) == 0 {
+	// Power symbols such as GND rarely contain the word, so all of them can
+	// be listed with only the power specifier.
+	if len(params.Keywords) == 0 && params.Power == nil {
 		return nil, ErrBadQuery{msg: "Keywords must be specified"}
 	}
 
+	params.Limit = fetchLimit
 	syms, err := db.SymbolSearch(ctx, params, db.DB())
 	if err != nil {
 		return nil, err
 	}
-	return rankSym(ctx, syms)
+	if syms, err = rankSym(ctx, syms); err != nil {
+		return nil, err
+	}
+	if !includePrivate {
+		syms = publicSymbols(syms)
+	}
+	return collapseSym(ctx, syms)
+}
+
+// publicSymbols returns the symbols which are not from private sources.
+func publicSymbols(syms []*db.Symbol) []*db.Symbol {
+	out := syms[:0]
+	for _, s := range syms {
+		if !s.Private {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// parseFlag parses the value of a yes/no specifier.
+func parseFlag(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes", "y":
+		return true, nil
+	case "no", "n":
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// collapseSym returns one symbol for each set of identical symbols, noting how
+// many other sources contain it.
+func collapseSym(ctx context.Context, syms []*db.Symbol) ([]*db.Symbol, error) {
+	hash := func(i int) string { return syms[i].ContentHash }
+	idx := group(len(syms), hash, func(i int) bool { return syms[i].Private })
+	counts, err := db.SymbolHashSources(ctx, distinctHashes(idx, hash), db.DB())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*db.Symbol, len(idx))
+	for i, x := range idx {
+		out[i] = syms[x]
+		// Only public sources are counted, so a private part is not
+		// among them.
+		n := counts[out[i].ContentHash]
+		if !out[i].Private {
+			n--
+		}
+		if n > 0 {
+			out[i].AlsoFoundIn = n
+		}
+	}
+	return out, nil
 }
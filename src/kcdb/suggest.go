@@ -0,0 +1,173 @@
+package kcdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"kcdb/db"
+	"kcdb/webhook"
+)
+
+// Limits on suggestions from a single address.
+const (
+	suggestionLimit  = 5
+	suggestionWindow = time.Hour
+	maxNoteLen       = 1000
+)
+
+// rateLimiter allows a fixed number of events per address within a window.
+type rateLimiter struct {
+	lock   sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+// allow records an event from addr, returning false if addr has reached its
+// limit.
+func (r *rateLimiter) allow(addr string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	var recent []time.Time
+	for _, t := range r.events[addr] {
+		if now.Sub(t) < r.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= r.limit {
+		r.events[addr] = recent
+		return false
+	}
+	r.events[addr] = append(recent, now)
+
+	// Forget addresses which have no recent events, so the map stays small.
+	for a, events := range r.events {
+		if now.Sub(events[len(events)-1]) >= r.window {
+			delete(r.events, a)
+		}
+	}
+	return true
+}
+
+var suggestionLimiter = &rateLimiter{
+	limit:  suggestionLimit,
+	window: suggestionWindow,
+	events: map[string][]time.Time{},
+}
+
+// suggestionURL checks that s looks like the URL of a publicly hosted git
+// repository, returning it in a canonical form.
+func suggestionURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 512 {
+		return "", errors.New("URL is too long")
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", errors.New("not a valid URL")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", errors.New("URL must use http or https")
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("URL must not contain credentials, a query or a fragment")
+	}
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") || host == "localhost" || strings.HasSuffix(host, ".local") {
+		return "", errors.New("URL must name a public host")
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if strings.Count(path, "/") < 1 {
+		return "", errors.New("URL must name a repository, such as https://github.com/user/repo")
+	}
+	return "https://" + host + "/" + path, nil
+}
+
+// remoteAddr returns the IP address a request was made from.
+func remoteAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// SuggestSource records a repository which a user would like to be added as
+// a source, for review by an admin.
+func SuggestSource(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var suggestion struct {
+		URL  string `json:"url"`
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(io.LimitReader(req.Body, 16*1024)).Decode(&suggestion); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	repoURL, err := suggestionURL(suggestion.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(suggestion.Note) > maxNoteLen {
+		http.Error(w, fmt.Sprintf("Note must be at most %d characters", maxNoteLen), http.StatusBadRequest)
+		return
+	}
+	addr := remoteAddr(req)
+	if !suggestionLimiter.allow(addr) {
+		http.Error(w, "Too many suggestions, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	sources, err := db.GetSources(req.Context(), db.DB())
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	for _, s := range sources {
+		if webhook.NormalizeURL(s.URL) == webhook.NormalizeURL(repoURL) {
+			http.Error(w, "That repository is already a source", http.StatusConflict)
+			return
+		}
+	}
+	pending, err := db.SuggestionPendingForURL(req.Context(), repoURL, db.DB())
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	if pending {
+		http.Error(w, "That repository has already been suggested", http.StatusConflict)
+		return
+	}
+
+	_, err = db.AddSourceSuggestion(req.Context(), &db.SourceSuggestion{
+		URL:        repoURL,
+		Note:       strings.TrimSpace(suggestion.Note),
+		RemoteAddr: addr,
+	}, db.DB())
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		fmt.Printf("Err: %v\n", err)
+		return
+	}
+	w.Write([]byte("OK."))
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/csv"
 	"errors"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 )
@@ -14,6 +15,23 @@ type Symbol struct {
 	Name                 string `json:"name"`
 	Reference            string `json:"reference"`
 	ReferenceYOffsetMils int
+	// Extends is the name of the symbol this symbol is derived from, if any.
+	Extends string `json:"extends,omitempty"`
+	// Units is the number of units in the symbol.
+	Units int `json:"units"`
+	// UnitsLocked is set if the units of the symbol are not interchangeable.
+	// It is only recorded by legacy libraries.
+	UnitsLocked bool `json:"units_locked"`
+	// DeMorgan is set if the symbol has an alternate (De Morgan) body style.
+	DeMorgan bool `json:"de_morgan"`
+	// Power is set for power symbols, such as GND.
+	Power bool `json:"power"`
+
+	// Description, Keywords and Datasheet document the symbol. Legacy
+	// libraries keep them in a separate .dcm file, see Document.
+	Description string `json:"description,omitempty"`
+	Keywords    string `json:"keywords,omitempty"`
+	Datasheet   string `json:"datasheet,omitempty"`
 
 	ShowPins  bool `json:"show_pins"`
 	ShowNames bool `json:"show_names"`
@@ -26,7 +44,9 @@ type Symbol struct {
 
 // SymbolFieldLine represents a data field on a symbol.
 type SymbolFieldLine struct {
-	Kind         int    `json:"kind"`
+	Kind int `json:"kind"`
+	// Name is the name of the property, for symbols from KiCad 6+ libraries.
+	Name         string `json:"name,omitempty"`
 	Value        string `json:"value"`
 	X            int
 	Y            int
@@ -42,9 +62,64 @@ type Pin struct {
 	X           int
 	Y           int
 	Orientation string `json:"orientation"`
+	// Length is the length of the pin, in mils.
+	Length int `json:"length"`
+	// Type is the electrical type of the pin, such as power_in.
+	Type string `json:"type,omitempty"`
+	// Shape is the graphical style of the pin, such as inverted.
+	Shape string `json:"shape,omitempty"`
+	// Unit is the unit the pin belongs to, or 0 if it is common to all units.
+	Unit int `json:"unit"`
+	// Convert is the body style the pin belongs to: 1 for the normal style,
+	// 2 for the De Morgan style, or 0 if it is common to both.
+	Convert int `json:"convert"`
+}
+
+// legacyPinTypes maps the electrical types of pins in legacy libraries to
+// the names used by KiCad 6+.
+var legacyPinTypes = map[string]string{
+	"I": "input",
+	"O": "output",
+	"B": "bidirectional",
+	"T": "tri_state",
+	"P": "passive",
+	"U": "unspecified",
+	"W": "power_in",
+	"w": "power_out",
+	"C": "open_collector",
+	"E": "open_emitter",
+	"N": "no_connect",
+}
+
+// legacyPinShapes maps the shapes of pins in legacy libraries, without the N
+// flag of invisible pins, to the names used by KiCad 6+.
+var legacyPinShapes = map[string]string{
+	"":   "line",
+	"I":  "inverted",
+	"C":  "clock",
+	"CI": "inverted_clock",
+	"IC": "inverted_clock",
+	"L":  "input_low",
+	"CL": "clock_low",
+	"LC": "clock_low",
+	"V":  "output_low",
+	"F":  "edge_clock_high",
+	"X":  "non_logic",
+}
+
+// legacyConvertIndex is the index of the body style (convert) value in each
+// kind of legacy draw line.
+var legacyConvertIndex = map[string]int{
+	"A": 7,
+	"B": 3,
+	"C": 5,
+	"P": 3,
+	"S": 6,
+	"T": 7,
 }
 
-// DecodeSymbolLibrary decodes an encoded representation of symbols.
+// DecodeSymbolLibrary decodes an encoded representation of symbols, in either
+// the legacy .lib format or the s-expression .kicad_sym format.
 func DecodeSymbolLibrary(r io.Reader) ([]*Symbol, error) {
 	b := bufio.NewReader(r)
 	var header string
@@ -53,17 +128,36 @@ func DecodeSymbolLibrary(r io.Reader) ([]*Symbol, error) {
 	for err == nil && strings.TrimSpace(header) == "" {
 		header, err = b.ReadString('\n')
 	}
-	if err != nil {
+	// A library may be written on a single line.
+	if err != nil && (err != io.EOF || strings.TrimSpace(header) == "") {
 		return nil, err
 	}
 
 	if strings.HasPrefix(header, "EESchema-LIBRARY Version 2.") {
 		return decodeV2Library(b)
 	}
+	if strings.HasPrefix(strings.TrimSpace(header), "(kicad_symbol_lib") {
+		rest, err := ioutil.ReadAll(b)
+		if err != nil {
+			return nil, err
+		}
+		return decodeKicadSymLibrary(header + string(rest))
+	}
 
 	return nil, nil
 }
 
+// DecodeStoredSymbol decodes a symbol as stored by the ingestor, which is
+// the definition of the symbol without the header of the library it came
+// from. Both legacy and KiCad 6+ symbols are supported.
+func DecodeStoredSymbol(raw []byte) ([]*Symbol, error) {
+	data := string(raw)
+	if strings.HasPrefix(strings.TrimSpace(data), "(") {
+		return DecodeSymbolLibrary(strings.NewReader("(kicad_symbol_lib\n" + data + "\n)"))
+	}
+	return DecodeSymbolLibrary(strings.NewReader("EESchema-LIBRARY Version 2.KEK\n" + data))
+}
+
 const (
 	parseStateNone = 0
 	parseStateDEF  = 1
@@ -100,6 +194,14 @@ func decodeV2Library(r *bufio.Reader) ([]*Symbol, error) {
 			}
 			p.ShowPins = spl[5] == "Y"
 			p.ShowNames = spl[6] == "Y"
+			p.Units = 1
+			if len(spl) > 7 {
+				if p.Units, err = strconv.Atoi(spl[7]); err != nil {
+					return nil, err
+				}
+			}
+			p.UnitsLocked = len(spl) > 8 && spl[8] == "L"
+			p.Power = len(spl) > 9 && spl[9] == "P"
 			parts = append(parts, &p)
 			parseState = parseStateDEF
 			p.RawData = line + "\n"
@@ -131,6 +233,9 @@ func decodeV2Library(r *bufio.Reader) ([]*Symbol, error) {
 			}
 			d.IsHorizontal = spl[5] == "H"
 			d.IsHidden = spl[6] == "I"
+			if d.Kind == FieldDatasheet {
+				parts[len(parts)-1].Datasheet = datasheetValue(d.Value)
+			}
 			parts[len(parts)-1].Fields = append(parts[len(parts)-1].Fields, d)
 			parts[len(parts)-1].RawData += line + "\n"
 		} else if strings.HasPrefix(line, "DRAW") && parseState == parseStateDEF {
@@ -155,7 +260,35 @@ func decodeV2Library(r *bufio.Reader) ([]*Symbol, error) {
 			if err != nil {
 				return nil, err
 			}
+			p.Length, err = strconv.Atoi(spl[5])
+			if err != nil {
+				return nil, err
+			}
 			p.Orientation = spl[6]
+			p.Unit, err = strconv.Atoi(spl[9])
+			if err != nil {
+				return nil, err
+			}
+			if len(spl) > 10 {
+				if p.Convert, err = strconv.Atoi(spl[10]); err != nil {
+					return nil, err
+				}
+			}
+			if len(spl) > 11 {
+				p.Type = legacyPinTypes[spl[11]]
+			}
+			if p.Type == "" {
+				p.Type = "unspecified"
+			}
+			p.Shape = "line"
+			if len(spl) > 12 {
+				if shape, ok := legacyPinShapes[strings.Replace(spl[12], "N", "", 1)]; ok {
+					p.Shape = shape
+				}
+			}
+			if p.Convert == 2 {
+				parts[len(parts)-1].DeMorgan = true
+			}
 			parts[len(parts)-1].Pins = append(parts[len(parts)-1].Pins, p)
 			parts[len(parts)-1].RawData += line + "\n"
 		} else if strings.HasPrefix(line, "ENDDRAW") && parseState == parseStateDRAW {
@@ -168,6 +301,9 @@ func decodeV2Library(r *bufio.Reader) ([]*Symbol, error) {
 			drawPrefixes := []string{"A", "C", "P", "S", "T", "B"}
 			for _, p := range drawPrefixes {
 				if strings.HasPrefix(line, p+" ") {
+					if f := strings.Fields(line); len(f) > legacyConvertIndex[p] && f[legacyConvertIndex[p]] == "2" {
+						parts[len(parts)-1].DeMorgan = true
+					}
 					parts[len(parts)-1].RawData += line + "\n"
 					break
 				}
@@ -196,3 +196,115 @@ ENDDEF`
 		t.Errorf("Expected RawData=%q, got %q.", expectedRawData, parts[0].RawData)
 	}
 }
+
+func TestDecoderUnitsAndPins(t *testing.T) {
+	f := bytes.NewBufferString(`EESchema-LIBRARY Version 2.3
+#encoding utf-8
+DEF 74LS00 U 0 40 Y Y 4 L N
+F0 "U" 0 50 50 H V C CNN
+F1 "74LS00" 0 -50 50 H V C CNN
+DRAW
+A 0 0 150 -899 899 1 1 0 f 0 -150 0 150
+P 4 1 2 0 -50 150 -150 150 -150 -150 -50 -150 N
+X ~ 1 -300 100 150 R 50 50 1 1 I
+X ~ 3 300 0 150 L 50 50 1 1 O I
+X ~ 1 -300 100 170 R 50 50 1 2 I I
+X VCC 14 0 350 200 D 50 50 0 0 W N
+X ~ 11 300 0 150 L 50 50 4 1 O CI
+ENDDRAW
+ENDDEF
+DEF GND #PWR 0 0 Y Y 1 F P
+F0 "#PWR" 0 -250 50 H I C CNN
+F1 "GND" 0 -150 50 H V C CNN
+DRAW
+X GND 1 0 0 0 D 50 50 1 1 W N
+ENDDRAW
+ENDDEF
+DEF R R 0 0 N Y 1 F N
+DRAW
+X ~ 1 0 150 50 D 50 50 1 1 P
+X ~ 2 0 -150 50 U 50 50 1 1 P
+ENDDRAW
+ENDDEF
+#End Library`)
+
+	parts, err := DecodeSymbolLibrary(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("Got %d parts, expected 3", len(parts))
+	}
+
+	p := parts[0]
+	if p.Units != 4 || !p.UnitsLocked || p.Power || !p.DeMorgan {
+		t.Errorf("Unexpected symbol flags: %d units, locked %v, power %v, De Morgan %v", p.Units, p.UnitsLocked, p.Power, p.DeMorgan)
+	}
+	if len(p.Pins) != 5 {
+		t.Fatalf("Expected 5 pins, got %d", len(p.Pins))
+	}
+	for i, want := range []Pin{
+		{Name: "~", Number: "3", X: 300, Y: 0, Length: 150, Orientation: "L", Type: "output", Shape: "inverted", Unit: 1, Convert: 1},
+		{Name: "~", Number: "1", X: -300, Y: 100, Length: 170, Orientation: "R", Type: "input", Shape: "inverted", Unit: 1, Convert: 2},
+		{Name: "VCC", Number: "14", X: 0, Y: 350, Length: 200, Orientation: "D", Type: "power_in", Shape: "line", Unit: 0, Convert: 0},
+		{Name: "~", Number: "11", X: 300, Y: 0, Length: 150, Orientation: "L", Type: "output", Shape: "inverted_clock", Unit: 4, Convert: 1},
+	} {
+		if p.Pins[i+1] != want {
+			t.Errorf("Pin %d = %+v, want %+v", i+1, p.Pins[i+1], want)
+		}
+	}
+
+	if p := parts[1]; !p.Power || p.Units != 1 || p.UnitsLocked || p.DeMorgan {
+		t.Errorf("Unexpected power symbol flags: %d units, locked %v, power %v, De Morgan %v", p.Units, p.UnitsLocked, p.Power, p.DeMorgan)
+	}
+	if p := parts[2]; p.Power || p.DeMorgan || p.Pins[0].Type != "passive" || p.Pins[0].Shape != "line" {
+		t.Errorf("Unexpected resistor: %+v", p)
+	}
+}
+
+func TestDecodeStoredSymbol(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+		pins int
+	}{
+		{
+			name: "legacy",
+			raw: `DEF R R 0 0 N Y 1 F N
+DRAW
+X ~ 1 0 150 50 D 50 50 1 1 P
+X ~ 2 0 -150 50 U 50 50 1 1 P
+ENDDRAW
+ENDDEF`,
+			pins: 2,
+		},
+		{
+			name: "kicad_sym",
+			raw: `(symbol "R" (in_bom yes) (on_board yes)
+    (property "Reference" "R" (id 0) (at 2.032 0 90)
+      (effects (font (size 1.27 1.27)))
+    )
+    (symbol "R_1_1"
+      (pin passive line (at 0 3.81 270) (length 1.27)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "1" (effects (font (size 1.27 1.27))))
+      )
+    )
+  )`,
+			pins: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parts, err := DecodeStoredSymbol([]byte(tc.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != 1 {
+				t.Fatalf("Got %d parts, expected 1", len(parts))
+			}
+			if parts[0].Name != "R" || len(parts[0].Pins) != tc.pins {
+				t.Errorf("Got %q with %d pins, want R with %d", parts[0].Name, len(parts[0].Pins), tc.pins)
+			}
+		})
+	}
+}
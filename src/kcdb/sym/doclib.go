@@ -0,0 +1,86 @@
+package sym
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Doc describes a symbol, as documented by a legacy .dcm file.
+type Doc struct {
+	Name        string
+	Description string
+	Keywords    string
+	Datasheet   string
+}
+
+// DecodeDocLibrary decodes an EESchema-DOCLIB .dcm file, which documents the
+// symbols of the .lib library with the same name. The documentation of each
+// symbol is returned keyed by the symbol's name.
+func DecodeDocLibrary(r io.Reader) (map[string]*Doc, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("invalid format: empty file")
+	}
+	if !strings.HasPrefix(s.Text(), "EESchema-DOCLIB") {
+		return nil, errors.New("invalid format: missing EESchema-DOCLIB header")
+	}
+
+	out := map[string]*Doc{}
+	var current *Doc
+	for line := 2; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(text, "$CMP "):
+			if current != nil {
+				return nil, fmt.Errorf("line %d: $CMP before $ENDCMP", line)
+			}
+			current = &Doc{Name: strings.TrimSpace(text[len("$CMP "):])}
+		case text == "$ENDCMP":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: $ENDCMP without $CMP", line)
+			}
+			out[current.Name] = current
+			current = nil
+		case current == nil:
+			// Comments such as #End Doc Library.
+		case strings.HasPrefix(text, "D "):
+			current.Description = strings.TrimSpace(text[2:])
+		case strings.HasPrefix(text, "K "):
+			current.Keywords = strings.TrimSpace(text[2:])
+		case strings.HasPrefix(text, "F "):
+			current.Datasheet = strings.TrimSpace(text[2:])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, errors.New("invalid format: unexpected end of file")
+	}
+	return out, nil
+}
+
+// Document sets the description and keywords of the symbol from d. The
+// datasheet is only taken from d if the symbol does not link to one.
+func (s *Symbol) Document(d *Doc) {
+	s.Description = d.Description
+	s.Keywords = d.Keywords
+	if s.Datasheet == "" {
+		s.Datasheet = d.Datasheet
+	}
+}
+
+// datasheetValue returns the datasheet linked by the value of a datasheet
+// field, which is ~ if there is none.
+func datasheetValue(v string) string {
+	if v == "~" {
+		return ""
+	}
+	return v
+}
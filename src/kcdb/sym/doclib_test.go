@@ -0,0 +1,87 @@
+package sym
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeDocLibrary(t *testing.T) {
+	docs, err := DecodeDocLibrary(strings.NewReader(`EESchema-DOCLIB  Version 2.0
+#
+$CMP 4001
+D Quad Nor 2 inputs
+K CMOS NOR2
+F http://www.intersil.com/content/dam/Intersil/documents/cd40/cd4000bms-01bms-02bms-25bms.pdf
+$ENDCMP
+#
+$CMP LM358
+D Low-Power, Dual Operational Amplifiers
+K dual opamp
+$ENDCMP
+#
+#End Doc Library
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("Got %d entries, expected 2", len(docs))
+	}
+	want := Doc{
+		Name:        "4001",
+		Description: "Quad Nor 2 inputs",
+		Keywords:    "CMOS NOR2",
+		Datasheet:   "http://www.intersil.com/content/dam/Intersil/documents/cd40/cd4000bms-01bms-02bms-25bms.pdf",
+	}
+	if d := docs["4001"]; d == nil || *d != want {
+		t.Errorf("docs[4001] = %+v, want %+v", d, want)
+	}
+	if d := docs["LM358"]; d == nil || d.Keywords != "dual opamp" || d.Datasheet != "" {
+		t.Errorf("Unexpected docs[LM358]: %+v", d)
+	}
+}
+
+func TestDecodeDocLibraryMalformed(t *testing.T) {
+	for _, lib := range []string{
+		"",
+		"EESchema-LIBRARY Version 2.3\n",
+		"EESchema-DOCLIB  Version 2.0\n$CMP R\nD Resistor\n",
+		"EESchema-DOCLIB  Version 2.0\n$CMP R\n$CMP C\n$ENDCMP\n",
+	} {
+		if _, err := DecodeDocLibrary(strings.NewReader(lib)); err == nil {
+			t.Errorf("Expected error decoding %q", lib)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	parts, err := DecodeSymbolLibrary(strings.NewReader(`EESchema-LIBRARY Version 2.3
+DEF R R 0 0 N Y 1 F N
+F0 "R" 80 0 50 V V C CNN
+F1 "R" 0 0 50 V V C CNN
+F2 "" -70 0 50 V I C CNN
+F3 "~" 0 0 50 H I C CNN
+ENDDEF
+DEF LED D 0 40 Y N 1 F N
+F0 "D" 0 100 50 H V C CNN
+F1 "LED" 0 -100 50 H V C CNN
+F2 "" 0 0 50 H I C CNN
+F3 "https://example.com/led.pdf" 0 0 50 H I C CNN
+ENDDEF
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("Got %d parts, expected 2", len(parts))
+	}
+
+	parts[0].Document(&Doc{Name: "R", Description: "Resistor", Keywords: "R res", Datasheet: "https://example.com/r.pdf"})
+	if r := parts[0]; r.Description != "Resistor" || r.Keywords != "R res" || r.Datasheet != "https://example.com/r.pdf" {
+		t.Errorf("Unexpected documentation of R: %q, %q, %q", r.Description, r.Keywords, r.Datasheet)
+	}
+	parts[1].Document(&Doc{Name: "LED", Datasheet: "https://example.com/other.pdf"})
+	if got, want := parts[1].Datasheet, "https://example.com/led.pdf"; got != want {
+		t.Errorf("Datasheet = %q, want %q", got, want)
+	}
+}
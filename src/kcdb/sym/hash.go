@@ -0,0 +1,48 @@
+package sym
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/sexp"
+)
+
+// ContentHash returns a hash of the symbol's definition which ignores
+// differences in whitespace and comments, so verbatim copies of a symbol in
+// different libraries have the same hash. Symbols from s-expression libraries
+// are hashed by their tokens, so line breaks and indentation are ignored too.
+func (s *Symbol) ContentHash() string {
+	h := sha256.New()
+	if strings.HasPrefix(strings.TrimSpace(s.RawData), "(") {
+		if ast, err := sexp.Parse(strings.NewReader(s.RawData), nil); err == nil {
+			writeTokens(h, ast.Children)
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	for _, line := range strings.Split(s.RawData, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		h.Write([]byte(strings.Join(fields, " ")))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeTokens writes n and the nodes following it to w, with a single
+// delimiter between tokens regardless of how they were laid out.
+func writeTokens(w io.Writer, n *sexp.Node) {
+	for ; n != nil; n = n.Next {
+		if n.IsList() {
+			io.WriteString(w, "(")
+			writeTokens(w, n.Children)
+			io.WriteString(w, ")")
+		} else {
+			io.WriteString(w, strconv.Quote(n.Value))
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package sym
+
+import "testing"
+
+func TestContentHash(t *testing.T) {
+	a := &Symbol{RawData: "DEF R R 0 0 N Y 1 F N\nF0 \"R\" 80 0 50 V V C CNN\nDRAW\nS -40 -100 40 100 0 1 10 N\nENDDRAW\nENDDEF"}
+	b := &Symbol{RawData: "DEF R R 0 0 N Y 1 F N\r\n# A comment\r\nF0  \"R\"   80 0 50 V V C CNN\r\n\r\nDRAW\r\n  S -40 -100 40 100 0 1 10 N\r\nENDDRAW\r\nENDDEF"}
+	c := &Symbol{RawData: "DEF R R 0 0 N Y 1 F N\nF0 \"R\" 80 0 50 V V C CNN\nDRAW\nS -40 -100 40 110 0 1 10 N\nENDDRAW\nENDDEF"}
+
+	if a.ContentHash() != b.ContentHash() {
+		t.Error("expected symbols differing only in whitespace and comments to have the same hash")
+	}
+	if a.ContentHash() == c.ContentHash() {
+		t.Error("expected symbols with different graphics to have different hashes")
+	}
+}
+
+func TestContentHashKicadSym(t *testing.T) {
+	a := &Symbol{RawData: "(symbol \"R\" (pin_names (offset 0))\n  (property \"Reference\" \"R\" (at 2.032 0 90))\n  (symbol \"R_0_1\"\n    (rectangle (start -1.016 -2.54) (end 1.016 2.54)))\n)"}
+	b := &Symbol{RawData: "(symbol \"R\"\n\t(pin_names\n\t\t(offset 0)\n\t)\n\t(property \"Reference\" \"R\"\n\t\t(at 2.032 0 90)\n\t)\n\t(symbol \"R_0_1\" (rectangle (start -1.016 -2.54) (end 1.016 2.54)))\n)"}
+	c := &Symbol{RawData: "(symbol \"R\" (pin_names (offset 0))\n  (property \"Reference\" \"R\" (at 2.032 0 90))\n  (symbol \"R_0_1\"\n    (rectangle (start -1.016 -2.54) (end 1.016 3.54)))\n)"}
+	d := &Symbol{RawData: "(symbol \"R\" (pin_names (offset 0))\n  (property \"Reference\" \"R 2.032\" (at 0 90))\n  (symbol \"R_0_1\"\n    (rectangle (start -1.016 -2.54) (end 1.016 2.54)))\n)"}
+
+	if a.ContentHash() != b.ContentHash() {
+		t.Error("expected symbols differing only in layout to have the same hash")
+	}
+	if a.ContentHash() == c.ContentHash() {
+		t.Error("expected symbols with different graphics to have different hashes")
+	}
+	if a.ContentHash() == d.ContentHash() {
+		t.Error("expected symbols with tokens moved into strings to have different hashes")
+	}
+}
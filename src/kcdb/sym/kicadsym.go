@@ -0,0 +1,410 @@
+package sym
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/sexp"
+)
+
+// Field kinds of the mandatory properties of a symbol, which match the field
+// numbers used by legacy libraries.
+const (
+	FieldReference = 0
+	FieldValue     = 1
+	FieldFootprint = 2
+	FieldDatasheet = 3
+)
+
+var mandatoryFields = map[string]int{
+	"Reference": FieldReference,
+	"Value":     FieldValue,
+	"Footprint": FieldFootprint,
+	"Datasheet": FieldDatasheet,
+}
+
+// defaultPinNameOffset is the offset of pin names used by KiCad when a
+// symbol does not specify one, in mils.
+const defaultPinNameOffset = 20
+
+// decodeKicadSymLibrary decodes a KiCad 6+ s-expression symbol library. Symbols
+// which extend another symbol in the library take their pins, units and body
+// styles from it, and are power symbols if it is. The units of the parent are
+// added to the raw data of such symbols, so they can be decoded on their own.
+func decodeKicadSymLibrary(data string) ([]*Symbol, error) {
+	lists, err := topLevelLists(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Symbol
+	byName := map[string]*Symbol{}
+	for _, l := range lists {
+		if !strings.HasPrefix(l, "(symbol") {
+			// Headers such as version and generator.
+			continue
+		}
+		ast, err := sexp.Parse(strings.NewReader(l), nil)
+		if err != nil {
+			return nil, err
+		}
+		n := sexp.Help(ast).Child(0)
+		s, err := decodeKicadSymbol(n)
+		if err != nil {
+			return nil, fmt.Errorf("symbol %d: %v", len(out)+1, err)
+		}
+		s.RawData = l
+		byName[s.Name] = s
+		out = append(out, s)
+	}
+
+	for _, s := range out {
+		if s.Extends == "" {
+			continue
+		}
+		parent, ok := byName[s.Extends]
+		if !ok {
+			continue
+		}
+		if len(s.Pins) == 0 {
+			s.Pins = parent.Pins
+			s.Units = parent.Units
+			s.DeMorgan = parent.DeMorgan
+			if s.RawData, err = flattenRawData(s, parent); err != nil {
+				return nil, err
+			}
+		}
+		s.Power = s.Power || parent.Power
+	}
+	return out, nil
+}
+
+// flattenRawData returns the raw data of the derived symbol s with the units
+// of its parent, along with the power flag if the parent has it, appended.
+func flattenRawData(s, parent *Symbol) (string, error) {
+	lists, err := topLevelLists(parent.RawData)
+	if err != nil {
+		return "", err
+	}
+	var inherited []string
+	for _, l := range lists {
+		if strings.HasPrefix(l, "(symbol") || (strings.HasPrefix(l, "(power") && !s.Power) {
+			inherited = append(inherited, l)
+		}
+	}
+	if len(inherited) == 0 {
+		return s.RawData, nil
+	}
+	body := strings.TrimSuffix(strings.TrimSpace(s.RawData), ")")
+	return body + "\n    " + strings.Join(inherited, "\n    ") + "\n  )", nil
+}
+
+// topLevelLists returns the text of each list within the single list which
+// makes up data.
+func topLevelLists(data string) ([]string, error) {
+	var (
+		out              []string
+		depth, start     int
+		inString, escape bool
+		seenRoot         bool
+	)
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case escape:
+			escape = false
+		case inString && c == '\\':
+			escape = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(':
+			if depth == 0 {
+				if seenRoot {
+					return nil, errors.New("invalid format: expected a single s-expression list at top level")
+				}
+				seenRoot = true
+			}
+			if depth++; depth == 2 {
+				start = i
+			}
+		case c == ')':
+			if depth == 0 {
+				return nil, errors.New("invalid format: unbalanced parentheses")
+			}
+			if depth--; depth == 1 {
+				out = append(out, data[start:i+1])
+			}
+		}
+	}
+	if depth != 0 || inString {
+		return nil, errors.New("invalid format: unexpected end of file")
+	}
+	return out, nil
+}
+
+func decodeKicadSymbol(n sexp.Helper) (*Symbol, error) {
+	if key, _ := n.Child(0).String(); key != "symbol" {
+		return nil, errors.New("invalid format: expected symbol")
+	}
+	name, err := n.Child(1).String()
+	if err != nil {
+		return nil, errors.New("invalid format: symbol name must be a string")
+	}
+	s := &Symbol{
+		Name:                 name,
+		ShowPins:             true,
+		ShowNames:            true,
+		ReferenceYOffsetMils: defaultPinNameOffset,
+		Units:                1,
+	}
+	userFields := 0
+
+	for i := 2; i < n.MustNode().NumChildren(); i++ {
+		c := n.Child(i)
+		if !c.IsList() {
+			continue
+		}
+		key, err := c.Child(0).String()
+		if err != nil {
+			return nil, errors.New("invalid format: expected string key")
+		}
+		switch key {
+		case "extends":
+			if s.Extends, err = c.Child(1).String(); err != nil {
+				return nil, errors.New("invalid format: extends must name a symbol")
+			}
+		case "power":
+			s.Power = true
+		case "pin_numbers":
+			s.ShowPins = !hidden(c)
+		case "pin_names":
+			s.ShowNames = !hidden(c)
+			if off, ok := child(c, "offset"); ok {
+				v, err := off.Child(1).Float64()
+				if err != nil {
+					return nil, errors.New("invalid format: pin name offset must be a number")
+				}
+				s.ReferenceYOffsetMils = mils(v)
+			}
+		case "property":
+			f, err := decodeProperty(c, FieldDatasheet+1+userFields)
+			if err != nil {
+				return nil, fmt.Errorf("property %d: %v", len(s.Fields)+1, err)
+			}
+			switch f.Name {
+			case "Reference":
+				s.Reference = f.Value
+			case "Datasheet":
+				s.Datasheet = datasheetValue(f.Value)
+			case "Description", "ki_description":
+				// KiCad 8 made the description a regular property.
+				s.Description = f.Value
+			case "ki_keywords":
+				s.Keywords = f.Value
+			}
+			if _, ok := mandatoryFields[f.Name]; !ok {
+				userFields++
+			}
+			s.Fields = append(s.Fields, *f)
+		case "symbol":
+			if err := s.decodeUnit(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// decodeProperty decodes a property. Properties other than the mandatory ones
+// are numbered from next if the library does not number them.
+func decodeProperty(n sexp.Helper, next int) (*SymbolFieldLine, error) {
+	name, err := n.Child(1).String()
+	if err != nil {
+		return nil, errors.New("invalid format: property name must be a string")
+	}
+	value, err := n.Child(2).String()
+	if err != nil {
+		return nil, errors.New("invalid format: property value must be a string")
+	}
+
+	f := &SymbolFieldLine{Name: name, Value: value, IsHorizontal: true}
+	if kind, ok := mandatoryFields[name]; ok {
+		f.Kind = kind
+	} else if id, ok := child(n, "id"); ok {
+		// KiCad 6 & 7 number every property.
+		if f.Kind, err = id.Child(1).Int(); err != nil {
+			return nil, errors.New("invalid format: property id must be an integer")
+		}
+	} else {
+		f.Kind = next
+	}
+
+	if at, ok := child(n, "at"); ok {
+		x, y, angle, err := position(at)
+		if err != nil {
+			return nil, err
+		}
+		f.X, f.Y = x, y
+		f.IsHorizontal = angle == 0 || angle == 180
+	}
+	f.IsHidden = hidden(n)
+	if effects, ok := child(n, "effects"); ok {
+		f.IsHidden = f.IsHidden || hidden(effects)
+		if font, ok := child(effects, "font"); ok {
+			if size, ok := child(font, "size"); ok {
+				h, err := size.Child(1).Float64()
+				if err != nil {
+					return nil, errors.New("invalid format: font size must be a number")
+				}
+				f.Size = mils(h)
+			}
+		}
+	}
+	return f, nil
+}
+
+// decodeUnit decodes the graphics and pins of one unit of a symbol, which are
+// named after the symbol, unit number and body style. Unit 0 is common to
+// every unit, and body style 0 to both body styles.
+func (s *Symbol) decodeUnit(n sexp.Helper) error {
+	name, err := n.Child(1).String()
+	if err != nil {
+		return errors.New("invalid format: unit name must be a string")
+	}
+	unit, style := 0, 0
+	if parts := strings.Split(name, "_"); len(parts) >= 3 {
+		if unit, err = strconv.Atoi(parts[len(parts)-2]); err != nil {
+			return fmt.Errorf("invalid format: bad unit name %q", name)
+		}
+		if style, err = strconv.Atoi(parts[len(parts)-1]); err != nil {
+			return fmt.Errorf("invalid format: bad unit name %q", name)
+		}
+	}
+	if unit > s.Units {
+		s.Units = unit
+	}
+	if style == 2 {
+		s.DeMorgan = true
+	}
+
+	for i := 2; i < n.MustNode().NumChildren(); i++ {
+		c := n.Child(i)
+		if key, _ := c.Child(0).String(); key != "pin" {
+			continue
+		}
+		p, err := decodePin(c)
+		if err != nil {
+			return fmt.Errorf("pin %d: %v", len(s.Pins)+1, err)
+		}
+		p.Unit, p.Convert = unit, style
+		s.Pins = append(s.Pins, *p)
+	}
+	return nil
+}
+
+func decodePin(n sexp.Helper) (*Pin, error) {
+	p := &Pin{}
+	var err error
+	if p.Type, err = n.Child(1).String(); err != nil {
+		return nil, errors.New("invalid format: pin type must be a string")
+	}
+	if p.Shape, err = n.Child(2).String(); err != nil {
+		return nil, errors.New("invalid format: pin shape must be a string")
+	}
+
+	at, ok := child(n, "at")
+	if !ok {
+		return nil, errors.New("invalid format: missing position")
+	}
+	x, y, angle, err := position(at)
+	if err != nil {
+		return nil, err
+	}
+	p.X, p.Y = x, y
+	switch angle {
+	case 90:
+		p.Orientation = "U"
+	case 180:
+		p.Orientation = "L"
+	case 270:
+		p.Orientation = "D"
+	default:
+		p.Orientation = "R"
+	}
+
+	if length, ok := child(n, "length"); ok {
+		v, err := length.Child(1).Float64()
+		if err != nil {
+			return nil, errors.New("invalid format: pin length must be a number")
+		}
+		p.Length = mils(v)
+	}
+	if name, ok := child(n, "name"); ok {
+		if p.Name, err = name.Child(1).String(); err != nil {
+			return nil, errors.New("invalid format: pin name must be a string")
+		}
+	}
+	if num, ok := child(n, "number"); ok {
+		if p.Number, err = num.Child(1).String(); err != nil {
+			return nil, errors.New("invalid format: pin number must be a string")
+		}
+	}
+	return p, nil
+}
+
+// child returns the first list within n which starts with key.
+func child(n sexp.Helper, key string) (sexp.Helper, bool) {
+	for i := 1; i < n.MustNode().NumChildren(); i++ {
+		c := n.Child(i)
+		if k, _ := c.Child(0).String(); c.IsList() && k == key {
+			return c, true
+		}
+	}
+	return sexp.Helper{}, false
+}
+
+// hidden returns true if n contains a hide flag, which is written as a bare
+// hide by KiCad 6 & 7, and as (hide yes) by KiCad 8.
+func hidden(n sexp.Helper) bool {
+	for i := 1; i < n.MustNode().NumChildren(); i++ {
+		c := n.Child(i)
+		if c.IsScalar() {
+			if v, _ := c.String(); v == "hide" {
+				return true
+			}
+			continue
+		}
+		if k, _ := c.Child(0).String(); k == "hide" {
+			v, err := c.Child(1).String()
+			return err != nil || v == "yes"
+		}
+	}
+	return false
+}
+
+// position decodes an (at x y angle) expression, returning the coordinates in
+// mils and the angle in degrees.
+func position(n sexp.Helper) (x, y, angle int, err error) {
+	var v [3]float64
+	for i := range v {
+		if !n.Child(i + 1).IsValid() {
+			if i == 2 {
+				break
+			}
+			return 0, 0, 0, errors.New("invalid format: missing coordinate")
+		}
+		if v[i], err = n.Child(i + 1).Float64(); err != nil {
+			return 0, 0, 0, errors.New("invalid format: coordinates must be numbers")
+		}
+	}
+	return mils(v[0]), mils(v[1]), int(math.Mod(v[2]+360, 360)), nil
+}
+
+// mils converts a length in millimeters to mils.
+func mils(mm float64) int {
+	return int(math.Round(mm / 0.0254))
+}
@@ -0,0 +1,390 @@
+package sym
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeKicadSymV6(t *testing.T) {
+	f := bytes.NewBufferString(`(kicad_symbol_lib (version 20211014) (generator kicad_symbol_editor)
+  (symbol "LM358" (pin_names (offset 0.127)) (in_bom yes) (on_board yes)
+    (property "Reference" "U" (id 0) (at 0 5.08 0)
+      (effects (font (size 1.27 1.27)) (justify left))
+    )
+    (property "Value" "LM358" (id 1) (at 0 -5.08 0)
+      (effects (font (size 1.27 1.27)) (justify left))
+    )
+    (property "Footprint" "" (id 2) (at 0 0 0)
+      (effects (font (size 1.27 1.27)) hide)
+    )
+    (property "Datasheet" "http://www.ti.com/lit/ds/symlink/lm2904-n.pdf" (id 3) (at 0 0 0)
+      (effects (font (size 1.27 1.27)) hide)
+    )
+    (property "ki_keywords" "dual opamp" (id 4) (at 0 0 0)
+      (effects (font (size 1.27 1.27)) hide)
+    )
+    (symbol "LM358_1_1"
+      (polyline
+        (pts (xy -5.08 5.08) (xy 5.08 0) (xy -5.08 -5.08) (xy -5.08 5.08))
+        (stroke (width 0.254) (type default) (color 0 0 0 0))
+        (fill (type background))
+      )
+      (pin output line (at 7.62 0 180) (length 2.54)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "1" (effects (font (size 1.27 1.27))))
+      )
+      (pin input line (at -7.62 -2.54 0) (length 2.54)
+        (name "-" (effects (font (size 1.27 1.27))))
+        (number "2" (effects (font (size 1.27 1.27))))
+      )
+      (pin input line (at -7.62 2.54 0) (length 2.54)
+        (name "+" (effects (font (size 1.27 1.27))))
+        (number "3" (effects (font (size 1.27 1.27))))
+      )
+    )
+    (symbol "LM358_2_1"
+      (pin input line (at -7.62 2.54 0) (length 2.54)
+        (name "+" (effects (font (size 1.27 1.27))))
+        (number "5" (effects (font (size 1.27 1.27))))
+      )
+      (pin input line (at -7.62 -2.54 0) (length 2.54)
+        (name "-" (effects (font (size 1.27 1.27))))
+        (number "6" (effects (font (size 1.27 1.27))))
+      )
+      (pin output line (at 7.62 0 180) (length 2.54)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "7" (effects (font (size 1.27 1.27))))
+      )
+    )
+    (symbol "LM358_3_1"
+      (pin power_in line (at -2.54 -7.62 90) (length 3.81)
+        (name "V-" (effects (font (size 1.27 1.27))))
+        (number "4" (effects (font (size 1.27 1.27))))
+      )
+      (pin power_in line (at -2.54 7.62 270) (length 3.81)
+        (name "V+" (effects (font (size 1.27 1.27))))
+        (number "8" (effects (font (size 1.27 1.27))))
+      )
+    )
+  )
+  (symbol "LM2904" (extends "LM358")
+    (property "Reference" "U" (id 0) (at 0 5.08 0)
+      (effects (font (size 1.27 1.27)) (justify left))
+    )
+    (property "Value" "LM2904" (id 1) (at 0 -5.08 0)
+      (effects (font (size 1.27 1.27)) (justify left))
+    )
+  )
+)
+`)
+
+	parts, err := DecodeSymbolLibrary(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("Got %d parts, expected 2", len(parts))
+	}
+
+	p := parts[0]
+	if p.Name != "LM358" {
+		t.Errorf("Name = %q, want LM358", p.Name)
+	}
+	if p.Reference != "U" {
+		t.Errorf("Reference = %q, want U", p.Reference)
+	}
+	if p.ReferenceYOffsetMils != 5 {
+		t.Errorf("ReferenceYOffsetMils = %d, want 5", p.ReferenceYOffsetMils)
+	}
+	if !p.ShowPins || !p.ShowNames {
+		t.Error("Expected pin numbers and names to be shown")
+	}
+	if p.Units != 3 {
+		t.Errorf("Units = %d, want 3", p.Units)
+	}
+	if p.Power || p.DeMorgan {
+		t.Errorf("Unexpected power & De Morgan flags: %v, %v", p.Power, p.DeMorgan)
+	}
+
+	if len(p.Fields) != 5 {
+		t.Fatalf("Expected 5 fields, got %d", len(p.Fields))
+	}
+	if f := p.Fields[3]; f.Kind != FieldDatasheet || f.Name != "Datasheet" || !f.IsHidden {
+		t.Errorf("Unexpected datasheet field: %+v", f)
+	}
+	if f := p.Fields[0]; f.Y != 200 || f.Size != 50 || f.IsHidden {
+		t.Errorf("Unexpected reference field: %+v", f)
+	}
+	if f := p.Fields[4]; f.Kind != 4 || f.Name != "ki_keywords" || f.Value != "dual opamp" {
+		t.Errorf("Unexpected keywords field: %+v", f)
+	}
+	if p.Keywords != "dual opamp" || p.Datasheet != "http://www.ti.com/lit/ds/symlink/lm2904-n.pdf" {
+		t.Errorf("Unexpected keywords & datasheet: %q, %q", p.Keywords, p.Datasheet)
+	}
+
+	if len(p.Pins) != 8 {
+		t.Fatalf("Expected 8 pins, got %d", len(p.Pins))
+	}
+	want := Pin{Name: "-", Number: "2", X: -300, Y: -100, Length: 100, Orientation: "R", Type: "input", Shape: "line", Unit: 1, Convert: 1}
+	if p.Pins[1] != want {
+		t.Errorf("Pin 2 = %+v, want %+v", p.Pins[1], want)
+	}
+	want = Pin{Name: "V+", Number: "8", X: -100, Y: 300, Length: 150, Orientation: "D", Type: "power_in", Shape: "line", Unit: 3, Convert: 1}
+	if p.Pins[7] != want {
+		t.Errorf("Pin 8 = %+v, want %+v", p.Pins[7], want)
+	}
+
+	if !strings.HasPrefix(p.RawData, `(symbol "LM358"`) || !strings.HasSuffix(p.RawData, ")") {
+		t.Errorf("Unexpected RawData: %q", p.RawData)
+	}
+
+	d := parts[1]
+	if d.Extends != "LM358" {
+		t.Errorf("Extends = %q, want LM358", d.Extends)
+	}
+	if len(d.Pins) != 8 || d.Units != 3 {
+		t.Errorf("Expected derived symbol to have the pins and units of its parent, got %d pins and %d units", len(d.Pins), d.Units)
+	}
+	if len(d.Fields) != 2 || d.Fields[1].Value != "LM2904" {
+		t.Errorf("Unexpected fields of derived symbol: %+v", d.Fields)
+	}
+
+	// Derived symbols are stored on their own, so must carry their parent's units.
+	stored, err := DecodeStoredSymbol([]byte(d.RawData))
+	if err != nil {
+		t.Fatalf("DecodeStoredSymbol(%q) failed: %v", d.RawData, err)
+	}
+	if len(stored) != 1 || stored[0].Name != "LM2904" || len(stored[0].Pins) != 8 || stored[0].Units != 3 {
+		t.Errorf("Unexpected stored derived symbol: %+v", stored)
+	}
+	if stored[0].Extends != "LM358" || len(stored[0].Fields) != 2 {
+		t.Errorf("Unexpected extends & fields of stored derived symbol: %q, %+v", stored[0].Extends, stored[0].Fields)
+	}
+}
+
+func TestDecodeKicadSymV8(t *testing.T) {
+	f := bytes.NewBufferString(`(kicad_symbol_lib
+	(version 20231120)
+	(generator "kicad_symbol_editor")
+	(generator_version "8.0")
+	(symbol "R"
+		(pin_numbers hide)
+		(pin_names
+			(offset 0)
+			(hide yes)
+		)
+		(exclude_from_sim no)
+		(in_bom yes)
+		(on_board yes)
+		(property "Reference" "R"
+			(at 2.032 0 90)
+			(effects
+				(font
+					(size 1.27 1.27)
+				)
+			)
+		)
+		(property "Value" "R"
+			(at 0 0 90)
+			(effects
+				(font
+					(size 1.27 1.27)
+				)
+			)
+		)
+		(property "Description" "Resistor \"generic\""
+			(at 0 0 0)
+			(effects
+				(font
+					(size 1.27 1.27)
+				)
+				(hide yes)
+			)
+		)
+		(symbol "R_0_1"
+			(rectangle
+				(start -1.016 -2.54)
+				(end 1.016 2.54)
+				(stroke
+					(width 0.254)
+					(type default)
+				)
+				(fill
+					(type none)
+				)
+			)
+		)
+		(symbol "R_1_1"
+			(pin passive line
+				(at 0 3.81 270)
+				(length 1.27)
+				(name "~"
+					(effects
+						(font
+							(size 1.27 1.27)
+						)
+					)
+				)
+				(number "1"
+					(effects
+						(font
+							(size 1.27 1.27)
+						)
+					)
+				)
+			)
+			(pin passive line
+				(at 0 -3.81 90)
+				(length 1.27)
+				(name "~"
+					(effects
+						(font
+							(size 1.27 1.27)
+						)
+					)
+				)
+				(number "2"
+					(effects
+						(font
+							(size 1.27 1.27)
+						)
+					)
+				)
+			)
+		)
+	)
+)`)
+
+	parts, err := DecodeSymbolLibrary(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 {
+		t.Fatalf("Got %d parts, expected 1", len(parts))
+	}
+
+	p := parts[0]
+	if p.Name != "R" || p.Reference != "R" {
+		t.Errorf("Unexpected name & reference: %q, %q", p.Name, p.Reference)
+	}
+	if p.ShowPins || p.ShowNames {
+		t.Error("Expected pin numbers and names to be hidden")
+	}
+	if p.ReferenceYOffsetMils != 0 {
+		t.Errorf("ReferenceYOffsetMils = %d, want 0", p.ReferenceYOffsetMils)
+	}
+	if p.Units != 1 {
+		t.Errorf("Units = %d, want 1", p.Units)
+	}
+	if len(p.Fields) != 3 {
+		t.Fatalf("Expected 3 fields, got %d", len(p.Fields))
+	}
+	if f := p.Fields[0]; f.IsHorizontal || f.X != 80 {
+		t.Errorf("Unexpected reference field: %+v", f)
+	}
+	if f := p.Fields[2]; f.Kind != 4 || f.Name != "Description" || f.Value != `Resistor "generic"` || !f.IsHidden {
+		t.Errorf("Unexpected description field: %+v", f)
+	}
+	if p.Description != `Resistor "generic"` {
+		t.Errorf("Description = %q, want %q", p.Description, `Resistor "generic"`)
+	}
+	if len(p.Pins) != 2 {
+		t.Fatalf("Expected 2 pins, got %d", len(p.Pins))
+	}
+	want := Pin{Name: "~", Number: "2", X: 0, Y: -150, Length: 50, Orientation: "U", Type: "passive", Shape: "line", Unit: 1, Convert: 1}
+	if p.Pins[1] != want {
+		t.Errorf("Pin 2 = %+v, want %+v", p.Pins[1], want)
+	}
+}
+
+func TestDecodeKicadSymPowerAndDeMorgan(t *testing.T) {
+	f := bytes.NewBufferString(`(kicad_symbol_lib (version 20211014) (generator kicad_symbol_editor)
+  (symbol "GND" (power) (pin_names (offset 0)) (in_bom yes) (on_board yes)
+    (property "Reference" "#PWR" (id 0) (at 0 -6.35 0)
+      (effects (font (size 1.27 1.27)) hide)
+    )
+    (property "Value" "GND" (id 1) (at 0 -3.81 0)
+      (effects (font (size 1.27 1.27)))
+    )
+    (symbol "GND_1_1"
+      (pin power_in line (at 0 0 270) (length 0) hide
+        (name "GND" (effects (font (size 1.27 1.27))))
+        (number "1" (effects (font (size 1.27 1.27))))
+      )
+    )
+  )
+  (symbol "GNDREF" (extends "GND")
+    (property "Reference" "#PWR" (id 0) (at 0 -6.35 0)
+      (effects (font (size 1.27 1.27)) hide)
+    )
+  )
+  (symbol "74LS00" (in_bom yes) (on_board yes)
+    (property "Reference" "U" (id 0) (at 0 1.27 0)
+      (effects (font (size 1.27 1.27)))
+    )
+    (symbol "74LS00_1_1"
+      (pin input line (at -7.62 2.54 0) (length 3.81)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "1" (effects (font (size 1.27 1.27))))
+      )
+      (pin output inverted (at 7.62 0 180) (length 3.81)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "3" (effects (font (size 1.27 1.27))))
+      )
+    )
+    (symbol "74LS00_1_2"
+      (pin input inverted (at -7.62 2.54 0) (length 3.81)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "1" (effects (font (size 1.27 1.27))))
+      )
+      (pin output line (at 7.62 0 180) (length 3.81)
+        (name "~" (effects (font (size 1.27 1.27))))
+        (number "3" (effects (font (size 1.27 1.27))))
+      )
+    )
+  )
+)
+`)
+
+	parts, err := DecodeSymbolLibrary(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("Got %d parts, expected 3", len(parts))
+	}
+	if p := parts[0]; !p.Power || p.DeMorgan || len(p.Pins) != 1 || p.Pins[0].Length != 0 {
+		t.Errorf("Unexpected power symbol: %+v", p)
+	}
+	if p := parts[1]; !p.Power || len(p.Pins) != 1 {
+		t.Errorf("Expected derived symbol to be a power symbol with the pins of its parent, got %+v", p)
+	}
+	if stored, err := DecodeStoredSymbol([]byte(parts[1].RawData)); err != nil || len(stored) != 1 || !stored[0].Power || len(stored[0].Pins) != 1 {
+		t.Errorf("DecodeStoredSymbol(%q) = %+v, %v, want a power symbol with 1 pin", parts[1].RawData, stored, err)
+	}
+
+	p := parts[2]
+	if p.Power || !p.DeMorgan || p.Units != 1 {
+		t.Errorf("Unexpected flags: power %v, De Morgan %v, %d units", p.Power, p.DeMorgan, p.Units)
+	}
+	if len(p.Pins) != 4 {
+		t.Fatalf("Expected 4 pins, got %d", len(p.Pins))
+	}
+	want := Pin{Name: "~", Number: "1", X: -300, Y: 100, Length: 150, Orientation: "R", Type: "input", Shape: "inverted", Unit: 1, Convert: 2}
+	if p.Pins[2] != want {
+		t.Errorf("Pin 1 (De Morgan) = %+v, want %+v", p.Pins[2], want)
+	}
+}
+
+func TestDecodeKicadSymMalformed(t *testing.T) {
+	for _, lib := range []string{
+		`(kicad_symbol_lib (version 20211014) (symbol "R"`,
+		`(kicad_symbol_lib (symbol "R" (symbol "R_1_1" (pin passive line (length 1.27)))))`,
+		`(kicad_symbol_lib (symbol R_1_1_ (symbol "R_x_1")))`,
+	} {
+		if _, err := DecodeSymbolLibrary(strings.NewReader(lib)); err == nil {
+			t.Errorf("Expected error decoding %q", lib)
+		}
+	}
+}
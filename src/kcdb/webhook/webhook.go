@@ -0,0 +1,205 @@
+// Package webhook decodes and authenticates push notifications sent by git
+// hosting services.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Providers which can send webhooks.
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+)
+
+// ErrBadSignature is returned if a webhook could not be authenticated.
+var ErrBadSignature = errors.New("webhook signature does not match")
+
+// Push describes a push to a repository.
+type Push struct {
+	Provider string
+	// Ref is the full name of the ref which was pushed, such as refs/heads/master.
+	Ref string
+	// DefaultBranch is the name of the default branch of the repository, if known.
+	DefaultBranch string
+	// URLs lists the URLs the repository can be cloned from.
+	URLs []string
+}
+
+// Provider returns the provider which sent a webhook with the given headers, or
+// the empty string if the provider is not known.
+func Provider(h http.Header) string {
+	// Gitea also sends GitHub headers, so must be checked first.
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		return ProviderGitea
+	case h.Get("X-GitHub-Event") != "":
+		return ProviderGitHub
+	case h.Get("X-Gitlab-Event") != "":
+		return ProviderGitLab
+	}
+	return ""
+}
+
+// IsPush returns true if the headers indicate a push event.
+func IsPush(h http.Header) bool {
+	switch Provider(h) {
+	case ProviderGitea:
+		return h.Get("X-Gitea-Event") == "push"
+	case ProviderGitHub:
+		return h.Get("X-GitHub-Event") == "push"
+	case ProviderGitLab:
+		return h.Get("X-Gitlab-Event") == "Push Hook" || h.Get("X-Gitlab-Event") == "Tag Push Hook"
+	}
+	return false
+}
+
+type repository struct {
+	CloneURL      string `json:"clone_url"`
+	HTMLURL       string `json:"html_url"`
+	SSHURL        string `json:"ssh_url"`
+	GitURL        string `json:"git_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+type project struct {
+	GitHTTPURL    string `json:"git_http_url"`
+	GitSSHURL     string `json:"git_ssh_url"`
+	WebURL        string `json:"web_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+type payload struct {
+	Ref        string      `json:"ref"`
+	Repository *repository `json:"repository"`
+	Project    *project    `json:"project"`
+}
+
+// Decode parses the body of a push webhook.
+func Decode(h http.Header, body []byte) (*Push, error) {
+	provider := Provider(h)
+	if provider == "" {
+		return nil, errors.New("unrecognised webhook provider")
+	}
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("decoding payload: %v", err)
+	}
+
+	out := &Push{Provider: provider, Ref: p.Ref}
+	switch provider {
+	case ProviderGitHub, ProviderGitea:
+		if p.Repository == nil {
+			return nil, errors.New("payload is missing repository")
+		}
+		out.DefaultBranch = p.Repository.DefaultBranch
+		out.URLs = nonEmpty(p.Repository.CloneURL, p.Repository.HTMLURL, p.Repository.SSHURL, p.Repository.GitURL)
+	case ProviderGitLab:
+		if p.Project == nil {
+			return nil, errors.New("payload is missing project")
+		}
+		out.DefaultBranch = p.Project.DefaultBranch
+		out.URLs = nonEmpty(p.Project.GitHTTPURL, p.Project.WebURL, p.Project.GitSSHURL)
+	}
+	if len(out.URLs) == 0 {
+		return nil, errors.New("payload does not identify the repository")
+	}
+	return out, nil
+}
+
+func nonEmpty(s ...string) []string {
+	var out []string
+	for _, v := range s {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Verify authenticates the body of a webhook against the secret configured
+// for the repository.
+func Verify(h http.Header, body []byte, secret string) error {
+	if secret == "" {
+		return ErrBadSignature
+	}
+	switch Provider(h) {
+	case ProviderGitHub:
+		sig := h.Get("X-Hub-Signature-256")
+		if !strings.HasPrefix(sig, "sha256=") {
+			return ErrBadSignature
+		}
+		return checkHMAC(sig[len("sha256="):], body, secret)
+	case ProviderGitea:
+		return checkHMAC(h.Get("X-Gitea-Signature"), body, secret)
+	case ProviderGitLab:
+		if subtle.ConstantTimeCompare([]byte(h.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	return errors.New("unrecognised webhook provider")
+}
+
+func checkHMAC(sig string, body []byte, secret string) error {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrBadSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Matches returns true if the push was to the repository at the given URL.
+func (p *Push) Matches(repoURL string) bool {
+	n := NormalizeURL(repoURL)
+	for _, u := range p.URLs {
+		if NormalizeURL(u) == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Affects returns true if the push updated the given branch or tag. If ref is
+// empty, the default branch of the repository is assumed.
+func (p *Push) Affects(ref string) bool {
+	if ref == "" {
+		ref = p.DefaultBranch
+	}
+	if ref == "" || p.Ref == "" {
+		return true
+	}
+	return p.Ref == ref || p.Ref == "refs/heads/"+ref || p.Ref == "refs/tags/"+ref
+}
+
+// NormalizeURL reduces a repository URL to its host and path, so the HTTP and
+// SSH URLs of a repository compare equal.
+func NormalizeURL(repoURL string) string {
+	s := strings.TrimSpace(repoURL)
+	if !strings.Contains(s, "://") {
+		// scp-like syntax, such as git@github.com:user/repo.git
+		if i := strings.Index(s, ":"); i > 0 {
+			s = "ssh://" + s[:i] + "/" + s[i+1:]
+		}
+	}
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = u.Hostname() + u.Path
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), ".git")
+	return strings.ToLower(s)
+}
@@ -75,6 +75,43 @@ app.controller('SourcesController', ["$scope", "$http", "$rootScope", "$interval
       });
     }
 
+    $scope.suggestion = {url: '', note: ''};
+    $scope.suggesting = false;
+    $scope.suggestResult = null;
+    $scope.suggest = function(){
+      $scope.suggesting = true;
+      $http({
+        method: 'POST',
+        url: '/sources/suggest',
+        data: $scope.suggestion,
+      }).then(function successCallback(response) {
+        $scope.suggesting = false;
+        $scope.suggestResult = {message: 'Thanks! Your suggestion will be reviewed soon.'};
+        $scope.suggestion = {url: '', note: ''};
+      }, function errorCallback(response) {
+        $scope.suggesting = false;
+        $scope.suggestResult = {error: true, message: response.data || 'Something went wrong, please try again later.'};
+      });
+    }
+
+    $scope.toggleErrors = function(source){
+      if (source.errors) {
+        source.errors = null;
+        return;
+      }
+      $scope.loading = true;
+      $http({
+        method: 'GET',
+        url: '/sources/' + source.uid + '/errors',
+      }).then(function successCallback(response) {
+        source.errors = response.data || [];
+        $scope.loading = false;
+      }, function errorCallback(response) {
+        $scope.loading = false;
+        $scope.error = response;
+      });
+    }
+
     $scope.hasUp = function(dateStr) {
       return !dateStr.startsWith('1970-');
     }
@@ -84,19 +121,89 @@ app.controller('SourcesController', ["$scope", "$http", "$rootScope", "$interval
     $scope.isNext = function(uid){
       return $scope.ingest_status && $scope.ingest_status.next_sources && $scope.ingest_status.next_sources[0].uid == uid;
     }
+    $scope.isIngesting = function(uid){
+      if (!$scope.ingest_status || !$scope.ingest_status.in_flight)return false;
+      for (var i = 0; i < $scope.ingest_status.in_flight.length; i++) {
+        if ($scope.ingest_status.in_flight[i].uid == uid)return true;
+      }
+      return false;
+    }
 
 
+    // progress describes the ingests in progress, keyed by source UID.
+    $scope.progress = {};
+    $scope.eventTypes = ['start', 'fetch', 'files', 'progress', 'pruned', 'vacuum', 'finish'];
+
+    $scope.listen = function(){
+      if (!window.EventSource) {
+        // Fall back to polling on browsers without server-sent events.
+        $scope.updater = $interval($scope.loadStatus, 23 * 1000);
+        return;
+      }
+      $scope.events = new EventSource('/ingestor/events');
+      $scope.eventTypes.forEach(function(type){
+        $scope.events.addEventListener(type, function(msg){
+          $scope.$apply(function(){
+            $scope.onEvent(JSON.parse(msg.data));
+          });
+        });
+      });
+    }
+
+    $scope.stopListening = function(){
+      if ($scope.events) {
+        $scope.events.close();
+        $scope.events = null;
+      }
+      if ($scope.updater) {
+        $interval.cancel($scope.updater);
+        $scope.updater =  null;
+      }
+      $scope.progress = {};
+    }
+
+    $scope.onEvent = function(e){
+      var p = $scope.progress[e.source_uid] || {};
+      switch (e.type) {
+        case 'start':
+          p = {phase: 'Starting', percent: null};
+          $scope.loadStatus();
+          break;
+        case 'fetch':
+          p.phase = e.message;
+          p.percent = e.percent;
+          break;
+        case 'files':
+          p.phase = 'Parsing ' + e.files + ' files';
+          p.percent = 0;
+          break;
+        case 'progress':
+          p.phase = 'Parsed ' + e.parsed + ' of ' + e.files + ' files' + (e.failed ? ', ' + e.failed + ' failed' : '');
+          p.percent = e.files ? Math.round(100 * e.parsed / e.files) : 100;
+          break;
+        case 'pruned':
+          p.phase = 'Removed ' + (e.pruned || 0) + ' parts which no longer exist';
+          break;
+        case 'vacuum':
+          p.phase = 'Compacting the database';
+          p.percent = null;
+          break;
+        case 'finish':
+          delete $scope.progress[e.source_uid];
+          $scope.load();
+          return;
+      }
+      $scope.progress[e.source_uid] = p;
+    }
+
     $rootScope.$on('page-change', function(event, args) {
       if (args.page == 'sources'){
-        if (!$scope.ingest_status) {
-          $scope.updater = $interval($scope.loadStatus, 23 * 1000);
+        if (!$scope.events && !$scope.updater) {
+          $scope.listen();
         }
         $scope.load();
       } else {
-        if ($scope.updater) {
-          $interval.cancel($scope.updater);
-          $scope.updater =  null;
-        }
+        $scope.stopListening();
       }
     });
 }]);
@@ -186,6 +293,29 @@ app.controller('SymbolViewController', ["$scope", "$rootScope", "$http", "$windo
   $scope.symbol = {};
   $scope.path = window.location.pathname.substring('/symbol/'.length);
   $scope.query = parseLocation($window.location.search)['query'];
+  // The unit and body style of the pins to show, where 0 shows all of them.
+  $scope.show = {unit: 0, convert: 0};
+
+  $scope.unitChoices = function(){
+    var out = [0];
+    for (var u = 1; u <= $scope.symbol.units; u++) {
+      out.push(u);
+    }
+    return out;
+  }
+
+  // shownPin returns true if the pin is common to, or part of, the selected
+  // unit and body style.
+  $scope.shownPin = function(p){
+    return (!$scope.show.unit || !p.unit || p.unit == $scope.show.unit) &&
+      (!$scope.show.convert || !p.convert || p.convert == $scope.show.convert);
+  }
+
+  // parentPath returns the path of the symbol this symbol extends, which is
+  // in the same library.
+  $scope.parentPath = function(){
+    return $scope.path.substring(0, $scope.path.lastIndexOf('::') + 2) + $scope.symbol.extends;
+  }
 
   $scope.load = function(user){
     $scope.loading = true;
@@ -5,6 +5,17 @@ app.controller('ViewController', ["$scope", "$rootScope", "$http", "$window", fu
   $scope.module = {};
   $scope.path = window.location.pathname.substring('/footprint/'.length);
   $scope.query = parseLocation($window.location.search)['query'];
+  // Footprints extracted from a board are named <repo>::<board file>::<footprint>,
+  // as are footprints from a legacy .mod library.
+  $scope.board = null;
+  $scope.legacyLibrary = null;
+  var pathParts = $scope.path.split('::');
+  if (pathParts.length > 2 && pathParts[1].endsWith('.kicad_pcb')) {
+    $scope.board = pathParts[1];
+  }
+  if (pathParts.length > 2 && pathParts[1].endsWith('.mod')) {
+    $scope.legacyLibrary = pathParts[1];
+  }
 
   $scope.canvas = document.getElementById('partsCanvas');
   $scope.canvas.style.width ='100%';
@@ -33,7 +44,7 @@ app.controller('ViewController', ["$scope", "$rootScope", "$http", "$window", fu
   $scope.redraw = paint;
 
   $scope.goto = function(){
-    window.location = 'https://' + $scope.path.replace('::', '/tree/master/');
+    window.location = 'https://' + pathParts.slice(0, 2).join('::').replace('::', '/tree/master/');
   }
 
   $scope.$watchGroup(['module'], function (newValue, oldValue, scope) {
@@ -116,6 +127,28 @@ app.controller('ViewController', ["$scope", "$rootScope", "$http", "$window", fu
             $scope.componentLayer.addChild(p);
             break;
 
+          case 'fp_rect':
+            var r = new $scope.paperSurface.Path.Rectangle(
+              new $scope.paperSurface.Point(g.renderable.start),
+              new $scope.paperSurface.Point(g.renderable.end));
+            r.strokeColor = resolveColor('rect', g.renderable.layer);
+            if (g.renderable.fill) {
+              r.fillColor = r.strokeColor;
+            }
+            r.strokeWidth = g.renderable.width * 15;
+            $scope.componentLayer.addChild(r);
+            break;
+
+          case 'fp_curve':
+            var pts = g.renderable.points;
+            var p = new $scope.paperSurface.Path();
+            p.moveTo(pts[0]);
+            p.cubicCurveTo(pts[1], pts[2], pts[3]);
+            p.strokeColor = resolveColor('curve', g.renderable.layer);
+            p.strokeWidth = g.renderable.width * 15;
+            $scope.componentLayer.addChild(p);
+            break;
+
           case 'fp_text':
             drawText(g);
             break;
@@ -128,6 +161,20 @@ app.controller('ViewController', ["$scope", "$rootScope", "$http", "$window", fu
       }
     }
 
+    // zones, such as keepout areas, are outlined.
+    if ($scope.module && $scope.module.zones)
+      for (var i = 0; i < $scope.module.zones.length; i++) {
+        var z = $scope.module.zones[i];
+        for (var j = 0; j < (z.base_polys || []).length; j++) {
+          var p = new $scope.paperSurface.Path(z.base_polys[j]);
+          p.closed = true;
+          p.strokeColor = resolveColor('zone', z.layers[0]);
+          p.strokeWidth = 0.5;
+          p.dashArray = [2, 2];
+          $scope.componentLayer.addChild(p);
+        }
+      }
+
     // pads
     if ($scope.module && $scope.module.pads)
       for (var i = 0; i < $scope.module.pads.length; i++) {
@@ -250,6 +297,9 @@ app.controller('ViewController', ["$scope", "$rootScope", "$http", "$window", fu
       radius: new $scope.paperSurface.Point(g.renderable.center).getDistance(g.renderable.end),
     });
     c.strokeColor = resolveColor('circle', g.renderable.layer);
+    if (g.renderable.fill) {
+      c.fillColor = c.strokeColor;
+    }
     c.strokeWidth = g.renderable.width * 5;
     $scope.componentLayer.addChild(c);
   }